@@ -0,0 +1,286 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "strings"
+
+  "github.com/cloudwego/hertz/pkg/common/hlog"
+  "github.com/litongjava/supers/internal/auth"
+  "github.com/litongjava/supers/internal/protocol"
+)
+
+// maxUnitSize 是 PUT unit 文件请求体的大小上限
+const maxUnitSize = 1 << 20
+
+// RegisterAPIRoutes 注册服务管理的 HTTP JSON 接口.
+// 每个路由都被转换为一个 protocol.Request, 与 unix socket 的 JSON 协议走同一个 handleRequest.
+//
+//   GET    /api/version
+//   GET    /api/services                          list
+//   GET    /api/services/{name}                   status
+//   DELETE /api/services/{name}                   delete
+//   POST   /api/services/{name}/start|stop|restart
+//   GET    /api/services/{name}/logs?n=&follow=&since=&until=&stream=
+//   GET    /api/services/{name}/unit              cat
+//   PUT    /api/services/{name}/unit              put, 请求体为 unit 文件内容
+//   DELETE /api/services/{name}/unit              delete
+//   POST   /api/reload
+//   GET    /api/webhooks                          webhook 投递状态
+//   GET    /api/events?service=&type=&n=&follow=&since=&until=&after=
+//
+// 请求头 Accept: text/event-stream 时以 Server-Sent Events 输出, 否则为 JSON / NDJSON.
+func RegisterAPIRoutes() {
+  http.HandleFunc("/api/", handleAPI)
+}
+
+func handleAPI(w http.ResponseWriter, r *http.Request) {
+  token, err := auth.Authenticate(r)
+  if err != nil {
+    hlog.Warnf("api: %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+    w.Header().Set("WWW-Authenticate", `Bearer realm="supers"`)
+    writeAPIError(w, http.StatusUnauthorized, protocol.CodeUnauthorized, err.Error())
+    return
+  }
+  req, status, msg := apiRequest(w, r)
+  if status != 0 {
+    writeAPIError(w, status, protocol.CodeBadRequest, msg)
+    return
+  }
+  if scope := commandScopes[req.Cmd]; !token.Allows(scope) {
+    hlog.Warnf("api: token %s is not allowed to %s %s (requires %s)", token.Name, r.Method, r.URL.Path, scope)
+    writeAPIError(w, http.StatusForbidden, protocol.CodeForbidden,
+      fmt.Sprintf("token %s does not have scope %s", token.Name, scope))
+    return
+  }
+  send := httpSender(w)
+  if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+    send = sseSender(w)
+  }
+  out := responder{
+    send: send,
+    done: func() <-chan struct{} { return r.Context().Done() },
+  }
+  if err := handleRequest(out, req); err != nil {
+    hlog.Warnf("api: write response failed: %v", err)
+  }
+}
+
+// apiRequest 把 HTTP 请求转换为 protocol.Request; 路径或方法不匹配时返回 HTTP 状态码和错误信息
+func apiRequest(w http.ResponseWriter, r *http.Request) (protocol.Request, int, string) {
+  parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/"), "/"), "/")
+  method := r.Method
+  req := protocol.Request{V: protocol.Version}
+  allow := func(m, cmd string, args ...string) bool {
+    if method != m {
+      return false
+    }
+    req.Cmd, req.Args = cmd, args
+    return true
+  }
+
+  switch {
+  case len(parts) == 1 && parts[0] == "version":
+    if allow(http.MethodGet, "version") {
+      return req, 0, ""
+    }
+  case len(parts) == 1 && parts[0] == "webhooks":
+    if allow(http.MethodGet, "webhooks") {
+      return req, 0, ""
+    }
+  case len(parts) == 1 && parts[0] == "events":
+    if allow(http.MethodGet, "events", eventsArgs(r)...) {
+      return req, 0, ""
+    }
+  case len(parts) == 1 && parts[0] == "reload":
+    if allow(http.MethodPost, "reload") {
+      return req, 0, ""
+    }
+  case len(parts) == 1 && parts[0] == "services":
+    if allow(http.MethodGet, "list") {
+      return req, 0, ""
+    }
+  case len(parts) == 2 && parts[0] == "services":
+    name := parts[1]
+    if allow(http.MethodGet, "status", name) || allow(http.MethodDelete, "delete", name) {
+      return req, 0, ""
+    }
+  case len(parts) == 3 && parts[0] == "services":
+    name := parts[1]
+    switch parts[2] {
+    case "start", "stop", "restart":
+      if allow(http.MethodPost, parts[2], name) {
+        return req, 0, ""
+      }
+    case "logs":
+      if allow(http.MethodGet, "logs", append(logsArgs(r), name)...) {
+        return req, 0, ""
+      }
+    case "unit":
+      if allow(http.MethodGet, "cat", name) || allow(http.MethodDelete, "delete", name) {
+        return req, 0, ""
+      }
+      if method == http.MethodPut {
+        body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxUnitSize))
+        if err != nil {
+          return req, http.StatusBadRequest, "read body: " + err.Error()
+        }
+        allow(http.MethodPut, "put", name, string(body))
+        return req, 0, ""
+      }
+    default:
+      return req, http.StatusNotFound, "no such endpoint " + r.URL.Path
+    }
+  default:
+    return req, http.StatusNotFound, "no such endpoint " + r.URL.Path
+  }
+  return req, http.StatusMethodNotAllowed, "method " + r.Method + " is not allowed on " + r.URL.Path
+}
+
+// logsArgs 把 ?n=&follow=&since=&until=&stream= 转换为 logs 命令的参数
+func logsArgs(r *http.Request) []string {
+  var args []string
+  q := r.URL.Query()
+  for _, key := range []string{"n", "since", "until", "stream"} {
+    if v := q.Get(key); v != "" {
+      args = append(args, "-"+key, v)
+    }
+  }
+  switch q.Get("follow") {
+  case "", "0", "false":
+  default:
+    args = append(args, "-f")
+  }
+  return args
+}
+
+// eventsArgs 把 ?service=&type=&n=&follow=&since=&until=&after= 转换为 events 命令的参数.
+// service 和 type 可以重复或用逗号分隔. EventSource 重连时带的 Last-Event-ID 等同于 after.
+func eventsArgs(r *http.Request) []string {
+  var args []string
+  q := r.URL.Query()
+  if q.Get("after") == "" {
+    if id := r.Header.Get("Last-Event-ID"); id != "" {
+      q.Set("after", id)
+    }
+  }
+  for _, key := range []string{"n", "since", "until", "after"} {
+    if v := q.Get(key); v != "" {
+      args = append(args, "-"+key, v)
+    }
+  }
+  if types := q["type"]; len(types) > 0 {
+    args = append(args, "-type", strings.Join(types, ","))
+  }
+  switch q.Get("follow") {
+  case "", "0", "false":
+  default:
+    args = append(args, "-f")
+  }
+  for _, s := range q["service"] {
+    args = append(args, strings.Split(s, ",")...)
+  }
+  return args
+}
+
+// httpSender 把响应写为 NDJSON. 状态码由第一个响应决定, 之后每个响应都立即 flush,
+// 这样 logs?follow=1 可以持续输出.
+func httpSender(w http.ResponseWriter) func(protocol.Response) error {
+  enc := json.NewEncoder(w)
+  flusher, _ := w.(http.Flusher)
+  wroteHeader := false
+  return func(resp protocol.Response) error {
+    if !wroteHeader {
+      wroteHeader = true
+      if resp.More {
+        w.Header().Set("Content-Type", "application/x-ndjson")
+      } else {
+        w.Header().Set("Content-Type", "application/json")
+      }
+      w.WriteHeader(apiStatus(resp.Error))
+    }
+    if err := enc.Encode(resp); err != nil {
+      return err
+    }
+    if flusher != nil {
+      flusher.Flush()
+    }
+    return nil
+  }
+}
+
+// sseSender 把响应写为 Server-Sent Events, 每个结果一条 data. 结果是事件时以 Seq 作为 id,
+// 浏览器的 EventSource 断线重连后从下一个事件继续. 第一个响应就失败时按普通 JSON 错误返回.
+func sseSender(w http.ResponseWriter) func(protocol.Response) error {
+  flusher, _ := w.(http.Flusher)
+  wroteHeader := false
+  return func(resp protocol.Response) error {
+    if !wroteHeader {
+      wroteHeader = true
+      if resp.Error != nil {
+        writeAPIError(w, apiStatus(resp.Error), resp.Error.Code, resp.Error.Message)
+        return nil
+      }
+      w.Header().Set("Content-Type", "text/event-stream")
+      w.Header().Set("Cache-Control", "no-cache")
+      w.WriteHeader(http.StatusOK)
+    }
+    var buf bytes.Buffer
+    switch {
+    case resp.Error != nil:
+      data, err := json.Marshal(resp.Error)
+      if err != nil {
+        return err
+      }
+      fmt.Fprintf(&buf, "event: error\ndata: %s\n\n", data)
+    case len(resp.Result) > 0:
+      var e struct {
+        Seq uint64 `json:"seq"`
+      }
+      if json.Unmarshal(resp.Result, &e) == nil && e.Seq > 0 {
+        fmt.Fprintf(&buf, "id: %d\n", e.Seq)
+      }
+      fmt.Fprintf(&buf, "data: %s\n\n", resp.Result)
+    default:
+      // 流结束时的空响应
+      return nil
+    }
+    if _, err := w.Write(buf.Bytes()); err != nil {
+      return err
+    }
+    if flusher != nil {
+      flusher.Flush()
+    }
+    return nil
+  }
+}
+
+// apiStatus 把协议错误码映射为 HTTP 状态码
+func apiStatus(e *protocol.Error) int {
+  if e == nil {
+    return http.StatusOK
+  }
+  switch e.Code {
+  case protocol.CodeBadRequest, protocol.CodeUnsupportedVersion:
+    return http.StatusBadRequest
+  case protocol.CodeNotFound, protocol.CodeUnknownCommand:
+    return http.StatusNotFound
+  case protocol.CodeUnauthorized:
+    return http.StatusUnauthorized
+  case protocol.CodeForbidden:
+    return http.StatusForbidden
+  }
+  return http.StatusInternalServerError
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, msg string) {
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(status)
+  json.NewEncoder(w).Encode(protocol.Response{
+    V:     protocol.Version,
+    Error: &protocol.Error{Code: code, Message: msg},
+  })
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+  "bytes"
+  "flag"
+  "strings"
+  "time"
+
+  "github.com/litongjava/supers/internal/events"
+  "github.com/litongjava/supers/internal/logger"
+)
+
+// eventsRequest 是解析后的 "events [-n N] [-f] [-since T] [-until T] [-type T,...] [-after SEQ] [service...]"
+type eventsRequest struct {
+  filter events.Filter
+  limit  int  // 0 表示全部
+  tail   bool // 是否先输出历史中的事件; -n 0 -f 只输出新事件
+  follow bool
+}
+
+func parseEvents(args []string) (eventsRequest, error) {
+  fs := flag.NewFlagSet("events", flag.ContinueOnError)
+  var usage bytes.Buffer
+  fs.SetOutput(&usage)
+  limit := fs.Int("n", -1, "number of events to show from the end")
+  follow := fs.Bool("f", false, "follow")
+  since := fs.String("since", "", "show events at or after this time")
+  until := fs.String("until", "", "show events at or before this time")
+  types := fs.String("type", "", "comma separated event types, * matches any characters")
+  after := fs.Uint64("after", 0, "show events with a larger seq")
+  if err := fs.Parse(args); err != nil {
+    return eventsRequest{}, err
+  }
+  req := eventsRequest{tail: *limit != 0, follow: *follow}
+  req.filter.Services = fs.Args()
+  req.filter.After = *after
+  if *types != "" {
+    req.filter.Types = strings.Split(*types, ",")
+  }
+  if err := req.filter.Validate(); err != nil {
+    return eventsRequest{}, err
+  }
+
+  // 与 logs 一致: 跟踪时默认先输出最后 10 个, 否则输出全部
+  req.limit = *limit
+  if *limit < 0 {
+    req.limit = 0
+    if *follow && *since == "" && *after == 0 {
+      req.limit = 10
+    }
+  }
+  now := time.Now()
+  for _, t := range []struct {
+    value string
+    dst   *time.Time
+  }{{*since, &req.filter.Since}, {*until, &req.filter.Until}} {
+    if t.value == "" {
+      continue
+    }
+    parsed, err := logger.ParseTime(t.value, now)
+    if err != nil {
+      return eventsRequest{}, err
+    }
+    *t.dst = parsed
+  }
+  return req, nil
+}
+
+// run 按时间顺序输出历史中的事件, 跟踪模式下继续输出新事件直到 done 被关闭
+func (req eventsRequest) run(done <-chan struct{}, write func(events.Event) error) error {
+  if !req.follow {
+    if !req.tail {
+      return nil
+    }
+    for _, e := range history.Query(req.filter, req.limit) {
+      if err := write(e); err != nil {
+        return err
+      }
+    }
+    return nil
+  }
+
+  past, ch, cancel := history.Follow(req.filter, req.limit)
+  defer cancel()
+  if !req.tail {
+    past = nil
+  }
+  for _, e := range past {
+    if err := write(e); err != nil {
+      return err
+    }
+  }
+  for {
+    select {
+    case e := <-ch:
+      if err := write(e); err != nil {
+        return err
+      }
+    case <-done:
+      return nil
+    }
+  }
+}
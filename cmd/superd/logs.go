@@ -0,0 +1,134 @@
+package main
+
+import (
+  "bytes"
+  "flag"
+  "fmt"
+  "io"
+  "time"
+
+  "github.com/litongjava/supers/internal/logger"
+  "github.com/litongjava/supers/internal/services"
+)
+
+// logsRequest 是解析后的 "logs [-n N] [-f] [-since T] [-until T] [-stream stdout|stderr] <name>"
+type logsRequest struct {
+  name   string
+  opts   logger.Options
+  query  logger.Query
+  tail   bool // 是否先输出已有的日志; -n 0 -f 只输出新内容
+  follow bool
+}
+
+// errServiceNotFound 用于在 JSON 协议中区分 not_found
+type errServiceNotFound string
+
+func (e errServiceNotFound) Error() string {
+  return "service " + string(e) + " not found"
+}
+
+func parseLogs(args []string) (logsRequest, error) {
+  fs := flag.NewFlagSet("logs", flag.ContinueOnError)
+  var usage bytes.Buffer
+  fs.SetOutput(&usage)
+  tail := fs.Int("n", -1, "number of lines to show from the end")
+  follow := fs.Bool("f", false, "follow")
+  since := fs.String("since", "", "show lines at or after this time")
+  until := fs.String("until", "", "show lines at or before this time")
+  stream := fs.String("stream", "", "stdout or stderr")
+  if err := fs.Parse(args); err != nil {
+    return logsRequest{}, err
+  }
+  if fs.NArg() != 1 {
+    return logsRequest{}, fmt.Errorf("no service name")
+  }
+  req := logsRequest{name: fs.Arg(0), tail: *tail != 0, follow: *follow}
+  if !services.ValidName(req.name) {
+    return logsRequest{}, fmt.Errorf("invalid service name %q", req.name)
+  }
+
+  cfg, ok := snapshotConfigs()[req.name]
+  if !ok {
+    return logsRequest{}, errServiceNotFound(req.name)
+  }
+  req.opts = cfg.Spec().Log
+
+  // 与 tail -f 一致: 跟踪时默认先输出最后 10 行, 否则输出全部
+  req.query.Tail = *tail
+  if *tail < 0 {
+    req.query.Tail = 0
+    if *follow && *since == "" {
+      req.query.Tail = 10
+    }
+  }
+  switch *stream {
+  case "", "all":
+  case "stdout", "stderr":
+    req.query.Stream = *stream
+  default:
+    return logsRequest{}, fmt.Errorf("unknown stream %q", *stream)
+  }
+  now := time.Now()
+  for _, t := range []struct {
+    value string
+    dst   *time.Time
+  }{{*since, &req.query.Since}, {*until, &req.query.Until}} {
+    if t.value == "" {
+      continue
+    }
+    parsed, err := logger.ParseTime(t.value, now)
+    if err != nil {
+      return logsRequest{}, err
+    }
+    *t.dst = parsed
+  }
+  return req, nil
+}
+
+// run 输出日志, 跟踪模式下持续到 done 被关闭
+func (req logsRequest) run(done <-chan struct{}, write func(logger.Line) error) error {
+  if req.tail {
+    if err := logger.Read(req.name, req.opts, req.query, write); err != nil {
+      return err
+    }
+  }
+  if !req.follow {
+    return nil
+  }
+  q := req.query
+  q.Tail = 0
+  return logger.Follow(req.name, req.opts, q, done, write)
+}
+
+// closed 在 r 读到 EOF 或出错(客户端断开, 如 Ctrl-C)时关闭返回的 channel
+func closed(r io.Reader) <-chan struct{} {
+  done := make(chan struct{})
+  go func() {
+    buf := make([]byte, 1)
+    for {
+      if _, err := r.Read(buf); err != nil {
+        close(done)
+        return
+      }
+    }
+  }()
+  return done
+}
+
+// logs 是文本协议的 logs 命令
+func logs(conn io.ReadWriter, args []string) {
+  req, err := parseLogs(args)
+  if err == nil {
+    var done <-chan struct{}
+    if req.follow {
+      done = closed(conn)
+    }
+    err = req.run(done, func(l logger.Line) error {
+      _, err := conn.Write([]byte(l.Text + "\n"))
+      return err
+    })
+  }
+  if err != nil {
+    fmt.Fprintf(conn, "error: logs: %v\n", err)
+  }
+}
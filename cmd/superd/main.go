@@ -1,9 +1,15 @@
 package main
 
 import (
+  "bufio"
+  "errors"
   "fmt"
   "github.com/cloudwego/hertz/pkg/common/hlog"
+  "github.com/litongjava/supers/internal/auth"
+  "github.com/litongjava/supers/internal/events"
+  "github.com/litongjava/supers/internal/logger"
   "github.com/litongjava/supers/internal/process"
+  "github.com/litongjava/supers/internal/protocol"
   "github.com/litongjava/supers/internal/services"
   "github.com/litongjava/supers/router"
   "github.com/litongjava/supers/utils"
@@ -12,6 +18,7 @@ import (
   "net/http"
   "os"
   "path/filepath"
+  "sort"
   "strconv"
   "strings"
   "sync"
@@ -20,10 +27,19 @@ import (
 var (
   serviceConfigs = make(map[string]services.ServiceConfig)
   configMutex    sync.Mutex
+
+  webhooks *events.WebhookHandler
+  history  *events.History
+  execs    *events.ExecHandler
 )
 
 const dir = "/etc/super"
-const sock = "/var/run/super.sock"
+
+// defaultStateFile 是 config.yml 中没有 state.file 时的状态文件
+const defaultStateFile = "/var/lib/super/state.json"
+
+// defaultPort 是 config.yml 中没有 app.port 时 HTTP 接口的端口
+const defaultPort = 10405
 
 func ensureDir(path string, perm os.FileMode) error {
   info, err := os.Stat(path)
@@ -56,35 +72,175 @@ func InitLog() (*os.File, error) {
 }
 
 // loadAndManageAll 从 /etc/super/*.service 重新加载所有配置，
-// 对比差异：新增 -> 启动；删除 -> 停止
+// 对比差异：新增 -> 启动；删除 -> 停止。启动/停止均按 After=/Before= 的依赖顺序进行
 func loadAndManageAll() error {
+  // config.yml 中的日志默认值也随 reload 生效; 读取失败时沿用上一次的配置
+  if err := utils.LoadConfig(); err != nil {
+    hlog.Warnf("reload config.yml failed: %v", err)
+  }
+  config := utils.CurrentConfig()
+  applyLogDefaults(config.Log)
+  var authConfig auth.Config
+  if app := config.App; app != nil {
+    authConfig.Tokens, authConfig.Password = app.Tokens, app.Password
+    if app.TLS != nil {
+      authConfig.Clients = app.TLS.Clients
+    }
+  }
+  if err := auth.Configure(authConfig); err != nil {
+    hlog.Errorf("load api tokens failed: %v", err)
+  }
+  if err := configureSocketAccess(config.Socket); err != nil {
+    hlog.Errorf("load socket access rules failed, only root may use the socket: %v", err)
+  }
+  eventsConfig := config.Events
+  if err := webhooks.Configure(eventsConfig); err != nil {
+    hlog.Errorf("configure webhooks failed: %v", err)
+  }
+  if err := history.Configure(eventsConfig); err != nil {
+    hlog.Errorf("configure event history failed: %v", err)
+  }
+  if err := execs.Configure(eventsConfig); err != nil {
+    hlog.Errorf("configure exec handlers failed: %v", err)
+  }
 
   newConfigs, err := services.LoadConfigs(dir)
-  if err != nil {
+  loadErr, partial := err.(*services.LoadError)
+  if err != nil && !partial {
     return err
   }
 
   configMutex.Lock()
   defer configMutex.Unlock()
 
-  // 停止已删除的
-  for name := range serviceConfigs {
+  // 解析失败的文件保留旧配置, 避免因为一次写错把正在运行的服务停掉
+  if partial {
+    for name, e := range loadErr.Errors {
+      hlog.Errorf("load %s failed: %v", name, e)
+      if old, ok := serviceConfigs[name]; ok {
+        newConfigs[name] = old
+      }
+    }
+  }
+
+  // 停止已删除的(逆依赖顺序)
+  for _, name := range services.StopOrder(serviceConfigs) {
     if _, ok := newConfigs[name]; !ok {
-      process.Stop(name)
+      if _, err := process.Stop(name); err != nil {
+        hlog.Errorf("stop %s failed: %v", name, err)
+      }
+      process.Forget(name)
     }
   }
 
-  // 启动新增的
-  for name, cfg := range newConfigs {
+  for _, m := range services.MissingRequirements(newConfigs) {
+    hlog.Warnf("missing dependency: %s", m)
+  }
+
+  // 启动新增的(依赖顺序); 存在循环依赖时仍然启动, 但把错误返回给 reload
+  order, orderErr := services.StartOrder(newConfigs)
+  for _, name := range order {
     if _, ok := serviceConfigs[name]; !ok {
-      process.Manage(name, cfg.Cmd, cfg.WorkingDirectory, cfg.RestartPolicy, cfg.Env)
+      spec := newConfigs[name].Spec()
+      // superd 重启后优先接管上次留下的进程, 被手动停止的服务保持停止
+      switch process.Restore(name, spec) {
+      case process.RestoreAdopted:
+        continue
+      case process.RestoreStopped:
+        hlog.Infof("%s was stopped manually before superd restarted; leaving it stopped", name)
+        continue
+      }
+      process.Manage(name, spec)
+    }
+  }
+
+  // 已在运行的服务: 日志配置变化时原地切换, 不重启进程
+  for name, cfg := range newConfigs {
+    old, ok := serviceConfigs[name]
+    if !ok {
+      continue
+    }
+    if newLog := cfg.Spec().Log; newLog != old.Spec().Log {
+      if err := process.UpdateLog(name, newLog); err != nil {
+        hlog.Errorf("update log settings of %s failed: %v", name, err)
+      }
+    }
+  }
+
+  // 状态文件中仍在运行、但配置已被删除的服务: 接管后停止
+  for _, name := range process.SavedNames() {
+    if _, ok := newConfigs[name]; ok {
+      continue
     }
+    if process.Restore(name, process.Spec{Stop: process.DefaultStopPolicy()}) == process.RestoreAdopted {
+      if _, err := process.Stop(name); err != nil {
+        hlog.Errorf("stop orphaned %s failed: %v", name, err)
+      }
+    }
+    process.Forget(name)
   }
 
   serviceConfigs = newConfigs
+
+  var msgs []string
+  if partial {
+    msgs = append(msgs, loadErr.Error())
+  }
+  if orderErr != nil {
+    msgs = append(msgs, orderErr.Error())
+  }
+  if len(msgs) > 0 {
+    return errors.New(strings.Join(msgs, "; "))
+  }
   return nil
 }
 
+// snapshotConfigs 复制当前配置, 避免持锁执行耗时的启动/停止
+func snapshotConfigs() map[string]services.ServiceConfig {
+  configMutex.Lock()
+  defer configMutex.Unlock()
+  configs := make(map[string]services.ServiceConfig, len(serviceConfigs))
+  for k, v := range serviceConfigs {
+    configs[k] = v
+  }
+  return configs
+}
+
+// applyLogDefaults 把 config.yml 中的 log 段设置为服务日志的全局默认值
+func applyLogDefaults(c *utils.LogConfig) {
+  d := logger.BuiltinDefaults()
+  if c == nil {
+    logger.SetDefaults(d)
+    return
+  }
+  if c.Dir != "" {
+    d.Dir = c.Dir
+  }
+  if c.MaxSize > 0 {
+    d.Rotation.MaxSize = c.MaxSize
+  }
+  if c.MaxBackups != nil {
+    d.Rotation.MaxBackups = *c.MaxBackups
+  }
+  if c.MaxAge != nil {
+    d.Rotation.MaxAge = *c.MaxAge
+  }
+  if c.Compress != nil {
+    d.Rotation.Compress = *c.Compress
+  }
+  interval, err := logger.ParseInterval(c.Rotate)
+  if err != nil {
+    hlog.Warnf("config.yml: log.rotate: %v", err)
+  }
+  d.Rotation.Interval = interval
+  format, err := logger.ParseFormat(c.Format)
+  if err != nil {
+    hlog.Warnf("config.yml: log.format: %v", err)
+  }
+  d.Format = format
+  logger.SetDefaults(d)
+}
+
 // handleConn 增加 reload 和 start 命令
 func handleConn(conn net.Conn) {
   defer func(conn net.Conn) {
@@ -93,8 +249,15 @@ func handleConn(conn net.Conn) {
       hlog.Error(err)
     }
   }(conn)
+  p := connPeer(conn)
+  // 以 '{' 开头的连接使用 JSON 协议, 否则按旧的文本命令处理
+  r := bufio.NewReader(conn)
+  if first, err := r.Peek(1); err == nil && first[0] == '{' {
+    serveJSON(conn, r, p)
+    return
+  }
   buf := make([]byte, 512)
-  n, _ := conn.Read(buf)
+  n, _ := r.Read(buf)
   fields := strings.Fields(string(buf[:n]))
   if len(fields) == 0 {
     return
@@ -104,9 +267,24 @@ func handleConn(conn net.Conn) {
   if len(fields) > 1 {
     name = fields[1]
   }
+  if err := p.allow(cmd, fields[1:]); err != nil {
+    hlog.Warnf("socket: %s: %v", p, err)
+    conn.Write([]byte("error: " + err.Error() + "\n"))
+    return
+  }
+  if namedCommands[cmd] && name != "" && !services.ValidName(name) {
+    conn.Write([]byte(fmt.Sprintf("error: invalid service name %q\n", name)))
+    return
+  }
   switch cmd {
   case "list":
-    for svc := range serviceConfigs {
+    configs := snapshotConfigs()
+    names := make([]string, 0, len(configs))
+    for svc := range configs {
+      names = append(names, svc)
+    }
+    sort.Strings(names)
+    for _, svc := range filterServices(names, p.statusServices()) {
       status := process.Status(svc)
       uptime := process.Uptime(svc)
       cmdSummary := process.Command(svc)
@@ -131,45 +309,19 @@ func handleConn(conn net.Conn) {
     line := fmt.Sprintf("%s %s %s %s %s\n", name, status, uptime, workingDirSummary, cmdSummary)
     conn.Write([]byte(line))
   case "stop":
-    stop(conn, name)
+    stop(textReporter{conn}, name)
   case "start":
-    start(conn, name)
+    start(textReporter{conn}, name)
 
   case "restart":
-    if name == "" {
-      conn.Write([]byte("error: no service name\n"))
-      return
-    }
-
-    // Stop() 会阻塞直到进程真正退出
-    if err := process.Stop(name); err != nil {
-      conn.Write([]byte("error: stop failed: " + err.Error() + "\n"))
-      return
-    }
-    conn.Write([]byte("stopped: " + name + "\n"))
-
-    // 重新加载配置
-    configMutex.Lock()
-    cfg, exists := serviceConfigs[name]
-    configMutex.Unlock()
+    restart(textReporter{conn}, name)
 
-    if !exists {
-      conn.Write([]byte("error: config not found\n"))
-      return
-    }
-
-    // ⭐ 同步启动并返回结果
-    conn.Write([]byte("starting: " + name + "\n"))
-    pid, err := process.Manage(name, cfg.Cmd, cfg.WorkingDirectory, cfg.RestartPolicy, cfg.Env)
-    if err != nil {
-      fmt.Fprintf(conn, "error: start failed: %v\n", err)
-    } else {
-      fmt.Fprintf(conn, "restarted: %s PID=%d\n", name, pid)
-    }
+  case "logs":
+    logs(conn, fields[1:])
 
   case "reload":
     if err := loadAndManageAll(); err != nil {
-      conn.Write([]byte("error: reload failed: " + err.Error() + "\n"))
+      conn.Write([]byte("error: reload: " + err.Error() + "\n"))
     } else {
       conn.Write([]byte("reloaded\n"))
     }
@@ -178,47 +330,183 @@ func handleConn(conn net.Conn) {
   }
 }
 
-func start(conn net.Conn, name string) {
+func start(out reporter, name string) {
   if name == "" {
-    fmt.Fprintln(conn, "error: no service name")
+    out.report(protocol.Action{Action: "error", Error: "no service name"})
     return
   }
 
   // 加载/确认 cfg
   configMutex.Lock()
-  cfg, exists := serviceConfigs[name]
+  _, exists := serviceConfigs[name]
   configMutex.Unlock()
 
   if !exists {
     c, err := services.LoadConfigFile(dir, name)
     if err != nil {
-      fmt.Fprintf(conn, "error: load config failed: %v\n", err)
+      out.report(protocol.Action{Service: name, Action: "error", Error: fmt.Sprintf("load config failed: %v", err)})
       return
     }
     configMutex.Lock()
     serviceConfigs[name] = c
     configMutex.Unlock()
-    cfg = c
   }
 
+  // 先拉起 Requires= / Wants= / BindsTo= 的服务; Requires= / BindsTo= 的服务启动失败时不再启动 name
+  configs := snapshotConfigs()
+  required := services.Requirements(configs, name)
+  for _, dep := range services.Dependencies(configs, name) {
+    if active(dep) {
+      continue
+    }
+    if !startOne(out, dep, configs[dep]) && required[dep] {
+      out.report(protocol.Action{Service: name, Action: "failed", Error: fmt.Sprintf("dependency %s failed to start", dep)})
+      return
+    }
+  }
+  startOne(out, name, configs[name])
+}
+
+// active 报告服务是否在运行或即将运行(启动中、停止中、等待重启)
+func active(name string) bool {
+  state, ok := process.GetState(name)
+  if !ok {
+    return false
+  }
+  switch state {
+  case process.StateInactive, process.StateFailed, process.StateExited:
+    return false
+  }
+  return true
+}
+
+// startOne 同步启动单个服务并回写结果
+func startOne(out reporter, name string, cfg services.ServiceConfig) bool {
   // ⭐ 同步启动,直接获取结果
-  pid, err := process.Manage(name, cfg.Cmd, cfg.WorkingDirectory, cfg.RestartPolicy, cfg.Env)
+  pid, err := process.Manage(name, cfg.Spec())
+  var active *process.ActiveError
+  if errors.As(err, &active) && active.State != process.StateStopping {
+    // 与 systemctl start 一致: 已在运行的服务不重复启动, 也不算失败
+    out.report(protocol.Action{Service: name, Action: "running", PID: active.PID})
+    return true
+  }
   if err != nil {
     hlog.Errorf("failed: %s error=%s", name, err.Error())
-    fmt.Fprintf(conn, "failed: %s error=%s\n", name, err.Error())
-  } else {
-    hlog.Infof("started: %s PID=%d", name, pid)
-    fmt.Fprintf(conn, "started: %s PID=%d\n", name, pid)
+    out.report(protocol.Action{Service: name, Action: "failed", Error: err.Error()})
+    return false
   }
+  hlog.Infof("started: %s PID=%d", name, pid)
+  out.report(protocol.Action{Service: name, Action: "started", PID: pid})
+  return true
 }
 
-func stop(conn net.Conn, name string) {
+func stop(out reporter, name string) {
   if name == "" {
-    conn.Write([]byte("error: no service name\n"))
-  } else if err := process.Stop(name); err != nil {
-    conn.Write([]byte("error: " + err.Error() + "\n"))
-  } else {
-    conn.Write([]byte("stopped: " + name + "\n"))
+    out.report(protocol.Action{Action: "error", Error: "no service name"})
+    return
+  }
+  stopWithDependents(out, name)
+}
+
+// stopWithDependents 先停止 Requires= / BindsTo= 依赖 name 的服务, 再停止 name 本身.
+// 返回被一并停止的依赖方(按停止顺序)以及 name 是否停止成功.
+func stopWithDependents(out reporter, name string) ([]string, bool) {
+  var stopped []string
+  for _, dep := range services.RequiredBy(snapshotConfigs(), name) {
+    // 处于 backoff 的服务也要停止, 否则退避结束后会被重新拉起
+    if !active(dep) {
+      continue
+    }
+    if result, err := process.Stop(dep); err != nil {
+      out.report(protocol.Action{Service: dep, Action: "error", Error: fmt.Sprintf("stop %s failed: %v", dep, err)})
+    } else {
+      out.report(protocol.Action{Service: dep, Action: "stopped", StopResult: result, RequiredBy: name})
+      stopped = append(stopped, dep)
+    }
+  }
+
+  result, err := process.Stop(name)
+  if err != nil {
+    out.report(protocol.Action{Service: name, Action: "error", Error: err.Error()})
+    return stopped, false
+  }
+  out.report(protocol.Action{Service: name, Action: "stopped", StopResult: result})
+  return stopped, true
+}
+
+// logReporter 把操作结果写入 superd 的日志, 用于不是由客户端发起的操作
+type logReporter struct{}
+
+func (logReporter) report(a protocol.Action) {
+  if a.Failed() {
+    hlog.Errorf("%s", a.String())
+    return
+  }
+  hlog.Infof("%s", a.String())
+}
+
+// bindings 实现 BindsTo=: 服务停止或退出(不再重启)时, 停止绑定到它的服务.
+// 服务处于 backoff 等待重启时不算停止.
+type bindings struct{}
+
+func (bindings) Handle(e events.Event) {
+  if e.Type != events.EventProcessStateChanged {
+    return
+  }
+  switch process.State(e.State) {
+  case process.StateInactive, process.StateFailed, process.StateExited:
+  default:
+    return
+  }
+  // 事件是异步处理的, 服务可能已被重新启动(如 restart)
+  if active(e.Name) {
+    return
+  }
+  for _, svc := range services.BoundBy(snapshotConfigs(), e.Name) {
+    if !active(svc) {
+      continue
+    }
+    hlog.Infof("%s is bound to %s, which is %s; stopping it", svc, e.Name, e.State)
+    stopWithDependents(logReporter{}, svc)
+  }
+}
+
+// restart 停止 name 及依赖它的服务, 然后按依赖顺序重新启动
+func restart(out reporter, name string) {
+  if name == "" {
+    out.report(protocol.Action{Action: "error", Error: "no service name"})
+    return
+  }
+
+  // Stop() 会阻塞直到进程真正退出
+  dependents, ok := stopWithDependents(out, name)
+  if !ok {
+    return
+  }
+
+  // 重新加载配置
+  configMutex.Lock()
+  cfg, exists := serviceConfigs[name]
+  configMutex.Unlock()
+
+  if !exists {
+    out.report(protocol.Action{Service: name, Action: "error", Error: "config not found"})
+    return
+  }
+
+  // ⭐ 同步启动并返回结果
+  out.report(protocol.Action{Service: name, Action: "starting"})
+  pid, err := process.Manage(name, cfg.Spec())
+  if err != nil {
+    out.report(protocol.Action{Service: name, Action: "error", Error: fmt.Sprintf("start failed: %v", err)})
+    return
+  }
+  out.report(protocol.Action{Service: name, Action: "restarted", PID: pid})
+
+  // 依赖方按启动顺序(停止顺序的逆序)恢复
+  configs := snapshotConfigs()
+  for i := len(dependents) - 1; i >= 0; i-- {
+    startOne(out, dependents[i], configs[dependents[i]])
   }
 }
 
@@ -233,30 +521,94 @@ func main() {
   if err := ensureDir(dir, 0o755); err != nil {
     hlog.Fatalf("ensure dir %s failed: %v", dir, err)
   }
+  // 事件历史、webhook 和 exec 在 loadAndManageAll 中按 config.yml 配置, 先注册以免漏掉启动时的事件
+  history = events.NewHistory()
+  events.RegisterSync(history)
+  webhooks = events.NewWebhookHandler()
+  events.Register(webhooks)
+  execs = events.NewExecHandler()
+  events.Register(execs)
+  events.Register(bindings{})
+  // 读取上次保存的进程状态, 用于接管仍在运行的服务; state 只在启动时读取
+  stateFile, outputFIFO := defaultStateFile, false
+  if st := utils.CurrentConfig().State; st != nil {
+    if st.File != "" {
+      stateFile = st.File
+    }
+    outputFIFO = st.OutputFIFO
+  }
+  if err := process.EnableState(stateFile); err != nil {
+    hlog.Errorf("load state %s failed: %v", stateFile, err)
+  }
+  process.EnableOutputFIFO(outputFIFO)
   // 初始加载
   if err := loadAndManageAll(); err != nil {
     hlog.Errorf("initial load failed: %v", err)
   }
 
-  // unix sock 服务
-  // 2) 确保 socket 的父目录存在（通常 /var/run）
-  if err := ensureParentDir(sock, 0o755); err != nil {
-    hlog.Fatalf("ensure parent dir for socket failed: %v", err)
+  // unix sock 服务; socket 的路径、属主和权限只在启动时读取
+  config := utils.CurrentConfig()
+  socketConfig := config.Socket
+  if socketConfig != nil && socketConfig.Path != "" {
+    sock = socketConfig.Path
+  }
+  ln, err := listenSocket(socketConfig)
+  if err != nil {
+    hlog.Fatalf("listen %s failed: %v", sock, err)
+  }
+  go serveSocket(ln)
+
+  // HTTP 控制接口; bind 和 tls 只在启动时读取
+  // 没有 config.yml 或其中没有 app.port 时使用默认端口
+  app := config.App
+  if app == nil {
+    app = &utils.App{}
+  }
+  port := app.Port
+  if port == 0 {
+    port = defaultPort
+  }
+  addr := net.JoinHostPort(app.Bind, strconv.Itoa(port))
+  router.RegisterRoutes()
+  RegisterAPIRoutes()
+  srv := &http.Server{Addr: addr}
+  if app.TLS != nil {
+    certs, err := newCertReloader(*app.TLS)
+    if err != nil {
+      hlog.Fatalf("%v", err)
+    }
+    srv.TLSConfig = certs.TLSConfig()
   }
-  os.Remove(sock)
-  ln, _ := net.Listen("unix", sock)
   go func() {
-    for {
-      conn, _ := ln.Accept()
-      go handleConn(conn)
+    var err error
+    if srv.TLSConfig != nil {
+      hlog.Infof("HTTPS on %s", addr)
+      err = srv.ListenAndServeTLS("", "")
+    } else {
+      hlog.Infof("HTTP on %s", addr)
+      err = srv.ListenAndServe()
+    }
+    if err != nil && err != http.ErrServerClosed {
+      hlog.Error(err.Error())
     }
   }()
 
-  // HTTP 控制接口
-  port := strconv.Itoa(utils.CONFIG.App.Port)
-  hlog.Infof("HTTP on %s", port)
-  router.RegisterRoutes()
-  if err := http.ListenAndServe(":"+port, nil); err != nil {
-    hlog.Error(err.Error())
+  waitForSignals(ln, srv)
+  // 事件历史在后台写入文件, 退出前写完停止服务时产生的事件
+  history.Flush()
+}
+
+// serveSocket 接受 unix socket 连接, listener 关闭后退出
+func serveSocket(ln net.Listener) {
+  for {
+    conn, err := ln.Accept()
+    if err != nil {
+      if errors.Is(err, net.ErrClosed) {
+        return
+      }
+      hlog.Errorf("accept failed: %v", err)
+      continue
+    }
+    go handleConn(conn)
   }
 }
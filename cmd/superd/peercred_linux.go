@@ -0,0 +1,33 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+  "fmt"
+  "net"
+  "syscall"
+)
+
+// peerCred 用 SO_PEERCRED 取得 unix socket 对端进程的 uid / gid / pid
+func peerCred(conn net.Conn) (uid, gid uint32, pid int32, err error) {
+  uc, ok := conn.(*net.UnixConn)
+  if !ok {
+    return 0, 0, 0, fmt.Errorf("not a unix socket connection")
+  }
+  raw, err := uc.SyscallConn()
+  if err != nil {
+    return 0, 0, 0, err
+  }
+  var cred *syscall.Ucred
+  var credErr error
+  if err := raw.Control(func(fd uintptr) {
+    cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+  }); err != nil {
+    return 0, 0, 0, err
+  }
+  if credErr != nil {
+    return 0, 0, 0, fmt.Errorf("SO_PEERCRED: %v", credErr)
+  }
+  return cred.Uid, cred.Gid, cred.Pid, nil
+}
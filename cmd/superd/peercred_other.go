@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+  "fmt"
+  "net"
+  "runtime"
+)
+
+// peerCred 只在 Linux 上实现; 其他平台配置了 socket.access 时所有连接都会被拒绝
+func peerCred(conn net.Conn) (uid, gid uint32, pid int32, err error) {
+  return 0, 0, 0, fmt.Errorf("peer credentials are not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+  "bufio"
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "io"
+  "os"
+  "sort"
+
+  "github.com/cloudwego/hertz/pkg/common/hlog"
+  "github.com/litongjava/supers/internal/events"
+  "github.com/litongjava/supers/internal/logger"
+  "github.com/litongjava/supers/internal/process"
+  "github.com/litongjava/supers/internal/protocol"
+  "github.com/litongjava/supers/internal/services"
+)
+
+// reporter 接收 start/stop/restart 对各个服务的操作结果:
+// 文本协议逐行写回, JSON 协议汇总到一个响应中
+type reporter interface {
+  report(a protocol.Action)
+}
+
+type textReporter struct {
+  w io.Writer
+}
+
+func (t textReporter) report(a protocol.Action) {
+  fmt.Fprintln(t.w, a.String())
+}
+
+type actionLog []protocol.Action
+
+func (l *actionLog) report(a protocol.Action) {
+  *l = append(*l, a)
+}
+
+// responder 接收一个请求的响应. 同一个请求可能收到多个响应(logs -f),
+// done 在客户端断开时关闭, 用于结束流式命令.
+type responder struct {
+  send     func(protocol.Response) error
+  done     func() <-chan struct{}
+  services []string // 非 nil 时 list 和 events 只输出这些服务
+}
+
+// serveJSON 处理 JSON 协议的连接: 每行一个请求, 按顺序逐个回复, 直到客户端关闭连接.
+// 流式请求(-f)通过读取连接来发现客户端断开, 之后的数据无法再作为请求读取, 所以它是连接上的最后一个请求
+func serveJSON(w io.Writer, r *bufio.Reader, p *peer) {
+  enc := json.NewEncoder(w)
+  streamed := false
+  out := responder{
+    send: func(resp protocol.Response) error { return enc.Encode(resp) },
+    done: func() <-chan struct{} {
+      streamed = true
+      return closed(r)
+    },
+  }
+  for {
+    line, readErr := r.ReadBytes('\n')
+    if line = bytes.TrimSpace(line); len(line) > 0 {
+      var req protocol.Request
+      var err error
+      if jsonErr := json.Unmarshal(line, &req); jsonErr != nil {
+        err = out.reply(req.ID, nil, &protocol.Error{Code: protocol.CodeBadRequest, Message: jsonErr.Error()}, false)
+      } else if permErr := p.allow(req.Cmd, req.Args); permErr != nil {
+        hlog.Warnf("socket: %s: %v", p, permErr)
+        err = out.reply(req.ID, nil, &protocol.Error{Code: protocol.CodeForbidden, Message: permErr.Error()}, false)
+      } else {
+        out.services = p.statusServices()
+        err = handleRequest(out, req)
+      }
+      if err != nil {
+        hlog.Warnf("socket: write response failed: %v", err)
+        return
+      }
+      if streamed {
+        return
+      }
+    }
+    if readErr != nil {
+      return
+    }
+  }
+}
+
+func (out responder) reply(id string, result interface{}, perr *protocol.Error, more bool) error {
+  resp := protocol.Response{V: protocol.Version, ID: id, OK: perr == nil, More: more, Error: perr}
+  if result != nil {
+    data, err := json.Marshal(result)
+    if err != nil {
+      return err
+    }
+    resp.Result = data
+  }
+  return out.send(resp)
+}
+
+// namedCommands 是第一个参数为服务名的命令. 服务名与 unit 文件名使用相同的规则(services.ValidName),
+// 避免用 ../ 之类的名字读取 /etc/super 以外的 unit 文件; logs 的服务名在 parseLogs 中检查
+var namedCommands = map[string]bool{
+  "status":  true,
+  "start":   true,
+  "stop":    true,
+  "restart": true,
+  "cat":     true,
+  "put":     true,
+  "delete":  true,
+}
+
+// handleRequest 执行一个 JSON 请求; unix socket 和 HTTP API 共用
+func handleRequest(out responder, req protocol.Request) error {
+  reply := func(result interface{}) error { return out.reply(req.ID, result, nil, false) }
+  fail := func(code, format string, a ...interface{}) error {
+    return out.reply(req.ID, nil, &protocol.Error{Code: code, Message: fmt.Sprintf(format, a...)}, false)
+  }
+
+  if req.V != 0 && req.V != protocol.Version {
+    return fail(protocol.CodeUnsupportedVersion, "protocol version %d is not supported, use %d", req.V, protocol.Version)
+  }
+  name := ""
+  if len(req.Args) > 0 {
+    name = req.Args[0]
+  }
+
+  if namedCommands[req.Cmd] && name != "" && !services.ValidName(name) {
+    return fail(protocol.CodeBadRequest, "invalid service name %q", name)
+  }
+
+  switch req.Cmd {
+  case "version":
+    return reply(map[string]int{"version": protocol.Version})
+
+  case "list":
+    configs := snapshotConfigs()
+    names := make([]string, 0, len(configs))
+    for n := range configs {
+      names = append(names, n)
+    }
+    sort.Strings(names)
+    names = filterServices(names, out.services)
+    list := make([]protocol.ServiceStatus, 0, len(names))
+    for _, n := range names {
+      list = append(list, process.Describe(n))
+    }
+    return reply(list)
+
+  case "status":
+    if name == "" {
+      return fail(protocol.CodeBadRequest, "no service name")
+    }
+    st := process.Describe(name)
+    if _, ok := snapshotConfigs()[name]; !ok && st.State == "not found" {
+      return fail(protocol.CodeNotFound, "service %s not found", name)
+    }
+    return reply(st)
+
+  case "start", "stop", "restart":
+    if name == "" {
+      return fail(protocol.CodeBadRequest, "no service name")
+    }
+    var actions actionLog
+    switch req.Cmd {
+    case "start":
+      start(&actions, name)
+    case "stop":
+      stop(&actions, name)
+    case "restart":
+      restart(&actions, name)
+    }
+    result := protocol.ActionsResult{Actions: actions}
+    for _, a := range actions {
+      if a.Failed() {
+        return out.reply(req.ID, result, &protocol.Error{Code: protocol.CodeFailed, Message: a.String()}, false)
+      }
+    }
+    return reply(result)
+
+  case "reload":
+    if err := loadAndManageAll(); err != nil {
+      return fail(protocol.CodeFailed, "reload: %v", err)
+    }
+    return reply(nil)
+
+  case "cat":
+    if name == "" {
+      return fail(protocol.CodeBadRequest, "no service name")
+    }
+    content, err := services.ReadUnitFile(dir, name)
+    if os.IsNotExist(err) {
+      return fail(protocol.CodeNotFound, "unit file of %s not found", name)
+    }
+    if err != nil {
+      return fail(protocol.CodeBadRequest, "cat: %v", err)
+    }
+    return reply(protocol.UnitFile{Name: name, Content: string(content)})
+
+  case "put":
+    // put <name> <content>: 校验并写入 unit 文件, 然后 reload 使其生效
+    if len(req.Args) != 2 || name == "" {
+      return fail(protocol.CodeBadRequest, "usage: put <name> <content>")
+    }
+    if err := services.WriteUnitFile(dir, name, []byte(req.Args[1])); err != nil {
+      return fail(protocol.CodeBadRequest, "put: %v", err)
+    }
+    if err := loadAndManageAll(); err != nil {
+      return fail(protocol.CodeFailed, "reload: %v", err)
+    }
+    return reply(nil)
+
+  case "delete":
+    // delete <name>: 删除 unit 文件, reload 时服务随之停止
+    if name == "" {
+      return fail(protocol.CodeBadRequest, "no service name")
+    }
+    err := services.RemoveUnitFile(dir, name)
+    if os.IsNotExist(err) {
+      return fail(protocol.CodeNotFound, "unit file of %s not found", name)
+    }
+    if err != nil {
+      return fail(protocol.CodeBadRequest, "delete: %v", err)
+    }
+    if err := loadAndManageAll(); err != nil {
+      return fail(protocol.CodeFailed, "reload: %v", err)
+    }
+    return reply(nil)
+
+  case "webhooks":
+    return reply(webhooks.Status())
+
+  case "events":
+    er, err := parseEvents(req.Args)
+    if err != nil {
+      return fail(protocol.CodeBadRequest, "events: %v", err)
+    }
+    if out.services != nil {
+      var ok bool
+      if er.filter, ok = er.filter.Restrict(out.services); !ok {
+        return fail(protocol.CodeForbidden, "events: permission denied for the requested services")
+      }
+    }
+    var done <-chan struct{}
+    if er.follow {
+      done = out.done()
+    }
+    err = er.run(done, func(e events.Event) error {
+      return out.reply(req.ID, e, nil, true)
+    })
+    if err != nil {
+      return fail(protocol.CodeFailed, "events: %v", err)
+    }
+    return reply(nil)
+
+  case "logs":
+    lr, err := parseLogs(req.Args)
+    if _, ok := err.(errServiceNotFound); ok {
+      return fail(protocol.CodeNotFound, "%v", err)
+    }
+    if err != nil {
+      return fail(protocol.CodeBadRequest, "logs: %v", err)
+    }
+    var done <-chan struct{}
+    if lr.follow {
+      done = out.done()
+    }
+    err = lr.run(done, func(l logger.Line) error {
+      line := protocol.LogLine{Stream: l.Stream, Line: l.Text}
+      if !l.Time.IsZero() {
+        line.Time = &l.Time
+      }
+      return out.reply(req.ID, line, nil, true)
+    })
+    if err != nil {
+      return fail(protocol.CodeFailed, "logs: %v", err)
+    }
+    return reply(nil)
+  }
+  return fail(protocol.CodeUnknownCommand, "unknown command %q", req.Cmd)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+  "testing"
+
+  "github.com/litongjava/supers/internal/protocol"
+)
+
+// recordResponses 返回把响应记到 list 中的 responder
+func recordResponses(list *[]protocol.Response) responder {
+  return responder{
+    send: func(resp protocol.Response) error {
+      *list = append(*list, resp)
+      return nil
+    },
+    done: func() <-chan struct{} { return nil },
+  }
+}
+
+func TestInvalidServiceName(t *testing.T) {
+  for _, req := range []protocol.Request{
+    {Cmd: "status", Args: []string{"../../tmp/x"}},
+    {Cmd: "start", Args: []string{"../../tmp/x"}},
+    {Cmd: "stop", Args: []string{"a/b"}},
+    {Cmd: "restart", Args: []string{".hidden"}},
+    {Cmd: "cat", Args: []string{"../x"}},
+    {Cmd: "put", Args: []string{"../x", "[Service]\nExecStart=/bin/true\n"}},
+    {Cmd: "delete", Args: []string{"../x"}},
+    {Cmd: "logs", Args: []string{"-n", "1", "../../tmp/x"}},
+  } {
+    var got []protocol.Response
+    if err := handleRequest(recordResponses(&got), req); err != nil {
+      t.Fatal(err)
+    }
+    if len(got) != 1 || got[0].Error == nil || got[0].Error.Code != protocol.CodeBadRequest {
+      t.Errorf("%s %q: responses = %+v, want one %s error", req.Cmd, req.Args, got, protocol.CodeBadRequest)
+    }
+  }
+}
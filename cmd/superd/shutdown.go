@@ -0,0 +1,103 @@
+package main
+
+import (
+  "context"
+  "net"
+  "net/http"
+  "os"
+  "os/signal"
+  "sync"
+  "syscall"
+  "time"
+
+  "github.com/cloudwego/hertz/pkg/common/hlog"
+  "github.com/litongjava/supers/internal/process"
+  "github.com/litongjava/supers/internal/services"
+  "github.com/litongjava/supers/utils"
+)
+
+// superd 退出时如何处理子进程
+const (
+  shutdownStop   = "stop"   // 按依赖逆序停止所有服务(默认)
+  shutdownDetach = "detach" // 保持服务运行, 下次启动时由状态文件接管
+)
+
+// httpShutdownTimeout 是关闭 HTTP 服务时等待进行中请求的时间
+const httpShutdownTimeout = 10 * time.Second
+
+func shutdownPolicy() string {
+  if app := utils.CurrentConfig().App; app != nil && app.ShutdownPolicy == shutdownDetach {
+    return shutdownDetach
+  }
+  return shutdownStop
+}
+
+// waitForSignals 阻塞直到收到 SIGTERM/SIGINT; SIGHUP 触发 reload
+func waitForSignals(ln net.Listener, srv *http.Server) {
+  sigCh := make(chan os.Signal, 1)
+  signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+  for sig := range sigCh {
+    if sig == syscall.SIGHUP {
+      hlog.Infof("received SIGHUP, reloading")
+      if err := loadAndManageAll(); err != nil {
+        hlog.Errorf("reload: %v", err)
+      }
+      continue
+    }
+    hlog.Infof("received %s, shutting down (shutdown_policy=%s)", sig, shutdownPolicy())
+    signal.Stop(sigCh)
+    shutdown(ln, srv)
+    return
+  }
+}
+
+// shutdown 先关闭控制入口, 再按策略处理服务
+func shutdown(ln net.Listener, srv *http.Server) {
+  if err := ln.Close(); err != nil {
+    hlog.Errorf("close socket failed: %v", err)
+  }
+  os.Remove(sock)
+
+  ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+  defer cancel()
+  if err := srv.Shutdown(ctx); err != nil {
+    hlog.Errorf("http shutdown failed: %v", err)
+  }
+
+  if shutdownPolicy() == shutdownDetach {
+    hlog.Infof("leaving services running for the next superd to adopt")
+    return
+  }
+
+  // 同一层的服务没有先后要求, 并行停止, 总耗时不超过各层中最长的 TimeoutStopSec 之和
+  for _, level := range services.StopLevels(snapshotConfigs()) {
+    var wg sync.WaitGroup
+    for _, name := range level {
+      wg.Add(1)
+      go func(name string) {
+        defer wg.Done()
+        stopForShutdown(name)
+      }(name)
+    }
+    wg.Wait()
+  }
+}
+
+// stopForShutdown 停止一个服务. 之前被手动停止的服务保留停止标志; 其余服务停止后不记录停止状态, 下次启动时正常拉起.
+// 停止失败时进程可能还在运行, 保留它的记录, 下次启动时由 Restore 接管, 不会再启动第二份
+func stopForShutdown(name string) {
+  state, _ := process.GetState(name)
+  switch state {
+  case process.StateInactive:
+    return
+  case process.StateStarting, process.StateRunning, process.StateStopping, process.StateBackoff:
+    // 处于 backoff 的服务也要停止, 否则退避结束后会被重新拉起
+    result, err := process.Stop(name)
+    if err != nil {
+      hlog.Errorf("stop %s failed, keeping its state for the next superd: %v", name, err)
+      return
+    }
+    hlog.Infof("stopped %s (%s)", name, result)
+  }
+  process.Forget(name)
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+  "fmt"
+  "net"
+  "os"
+  "os/user"
+  "sort"
+  "strconv"
+  "sync"
+
+  "github.com/litongjava/supers/internal/auth"
+  "github.com/litongjava/supers/internal/process"
+  "github.com/litongjava/supers/internal/services"
+  "github.com/litongjava/supers/utils"
+)
+
+const defaultSock = "/var/run/super.sock"
+
+// sock 是控制 socket 的路径, 启动时由 config.yml 的 socket.path 决定
+var sock = defaultSock
+
+// commandScopes 是各命令需要的 scope; unix socket 的访问规则和 HTTP token 共用
+var commandScopes = map[string]auth.Scope{
+  "version":  auth.ScopeStatus,
+  "list":     auth.ScopeStatus,
+  "status":   auth.ScopeStatus,
+  "logs":     auth.ScopeStatus,
+  "cat":      auth.ScopeStatus,
+  "webhooks": auth.ScopeStatus,
+  "events":   auth.ScopeStatus,
+  "start":    auth.ScopeDeploy,
+  "stop":     auth.ScopeDeploy,
+  "restart":  auth.ScopeDeploy,
+  "put":      auth.ScopeAdmin,
+  "delete":   auth.ScopeAdmin,
+  "reload":   auth.ScopeAdmin,
+}
+
+// commandService 返回命令操作的服务名; logs 的服务名在参数最后.
+// events 可以查看多个服务, 由 statusServices 在执行时限制.
+func commandService(cmd string, args []string) string {
+  if len(args) == 0 || cmd == "events" {
+    return ""
+  }
+  if cmd == "logs" {
+    return args[len(args)-1]
+  }
+  return args[0]
+}
+
+// listenSocket 创建控制 socket 并按配置设置属主和权限(默认 0600, 只有 superd 的用户可以连接)
+func listenSocket(cfg *utils.SocketConfig) (net.Listener, error) {
+  mode := os.FileMode(0o600)
+  uid, gid := -1, -1
+  if cfg != nil {
+    if cfg.Mode != "" {
+      m, err := strconv.ParseUint(cfg.Mode, 8, 32)
+      if err != nil || m > 0o777 {
+        return nil, fmt.Errorf("socket: invalid mode %q", cfg.Mode)
+      }
+      mode = os.FileMode(m)
+    }
+    if cfg.Owner != "" {
+      u, err := process.LookupUID(cfg.Owner)
+      if err != nil {
+        return nil, fmt.Errorf("socket: %v", err)
+      }
+      uid = int(u)
+    }
+    if cfg.Group != "" {
+      g, err := process.LookupGID(cfg.Group)
+      if err != nil {
+        return nil, fmt.Errorf("socket: %v", err)
+      }
+      gid = int(g)
+    }
+  }
+
+  if err := ensureParentDir(sock, 0o755); err != nil {
+    return nil, fmt.Errorf("ensure parent dir for socket failed: %v", err)
+  }
+  os.Remove(sock)
+  ln, err := net.Listen("unix", sock)
+  if err != nil {
+    return nil, err
+  }
+  if err := os.Chmod(sock, mode); err != nil {
+    ln.Close()
+    return nil, err
+  }
+  if uid != -1 || gid != -1 {
+    if err := os.Chown(sock, uid, gid); err != nil {
+      ln.Close()
+      return nil, err
+    }
+  }
+  return ln, nil
+}
+
+// socketRule 是解析后的 utils.SocketRule
+type socketRule struct {
+  uids     map[uint32]bool
+  gids     map[uint32]bool
+  scopes   auth.Scopes
+  services map[string]bool
+}
+
+var (
+  accessMu    sync.RWMutex
+  accessRules []socketRule
+  accessOn    bool // 未配置 socket.access 时不限制, 能连接 socket 的用户都有全部权限
+)
+
+// configureSocketAccess 加载 socket.access, 随 reload 生效; 配置有误时拒绝所有非 root 连接
+func configureSocketAccess(cfg *utils.SocketConfig) error {
+  var rules []socketRule
+  on := cfg != nil && len(cfg.Access) > 0
+  var err error
+  if on {
+    rules, err = parseSocketRules(cfg.Access)
+  }
+  accessMu.Lock()
+  accessRules, accessOn = rules, on
+  accessMu.Unlock()
+  return err
+}
+
+func parseSocketRules(list []utils.SocketRule) ([]socketRule, error) {
+  rules := make([]socketRule, 0, len(list))
+  for i, c := range list {
+    r := socketRule{uids: map[uint32]bool{}, gids: map[uint32]bool{}, services: map[string]bool{}}
+    for _, name := range c.Users {
+      uid, err := process.LookupUID(name)
+      if err != nil {
+        return nil, fmt.Errorf("socket.access[%d]: %v", i, err)
+      }
+      r.uids[uid] = true
+    }
+    for _, name := range c.Groups {
+      gid, err := process.LookupGID(name)
+      if err != nil {
+        return nil, fmt.Errorf("socket.access[%d]: %v", i, err)
+      }
+      r.gids[gid] = true
+    }
+    if len(r.uids) == 0 && len(r.gids) == 0 {
+      return nil, fmt.Errorf("socket.access[%d]: no users or groups", i)
+    }
+    scopes, err := auth.ParseScopes(c.Scopes)
+    if err != nil {
+      return nil, fmt.Errorf("socket.access[%d]: %v", i, err)
+    }
+    if scopes[auth.ScopeExec] {
+      return nil, fmt.Errorf("socket.access[%d]: scope exec is only used by the HTTP API", i)
+    }
+    r.scopes = scopes
+    for _, s := range c.Services {
+      r.services[s] = true
+    }
+    if r.scopes[auth.ScopeAdmin] && len(r.services) > 0 {
+      // admin 可以修改 unit 文件(包括 User=), 按服务限制没有意义
+      return nil, fmt.Errorf("socket.access[%d]: scope admin cannot be limited to services", i)
+    }
+    rules = append(rules, r)
+  }
+  return rules, nil
+}
+
+// peer 是 socket 连接另一端的进程
+type peer struct {
+  uid, gid uint32
+  pid      int32
+  groups   map[uint32]bool
+  err      error // 取不到对端身份时的原因
+}
+
+func (p *peer) String() string {
+  if p.err != nil {
+    return "unknown peer"
+  }
+  return fmt.Sprintf("pid=%d uid=%d gid=%d", p.pid, p.uid, p.gid)
+}
+
+// connPeer 通过 SO_PEERCRED 取得对端的 uid / gid / pid 以及附加组
+func connPeer(conn net.Conn) *peer {
+  uid, gid, pid, err := peerCred(conn)
+  if err != nil {
+    return &peer{err: err}
+  }
+  p := &peer{uid: uid, gid: gid, pid: pid, groups: map[uint32]bool{gid: true}}
+  if u, err := user.LookupId(strconv.Itoa(int(uid))); err == nil {
+    if ids, err := u.GroupIds(); err == nil {
+      for _, id := range ids {
+        if n, err := strconv.Atoi(id); err == nil {
+          p.groups[uint32(n)] = true
+        }
+      }
+    }
+  }
+  return p
+}
+
+// namelessCommands 是不针对某个服务的只读命令, 不受规则中服务列表的限制;
+// list 和 events 的内容另由 statusServices 限制
+var namelessCommands = map[string]bool{
+  "version":  true,
+  "list":     true,
+  "webhooks": true,
+  "events":   true,
+}
+
+// allow 检查 peer 能否执行 cmd; 拒绝时返回原因.
+// stop / restart 会一并停止 Requires= / BindsTo= 依赖该服务的服务, 这些服务也必须有权限.
+func (p *peer) allow(cmd string, args []string) error {
+  service := commandService(cmd, args)
+  var dependents []string
+  if (cmd == "stop" || cmd == "restart") && service != "" {
+    dependents = services.RequiredBy(snapshotConfigs(), service)
+  }
+
+  accessMu.RLock()
+  defer accessMu.RUnlock()
+  if !accessOn {
+    return nil
+  }
+  if p.err != nil {
+    return fmt.Errorf("permission denied: %v", p.err)
+  }
+  // root 和 superd 自己的用户不受规则限制
+  if p.uid == 0 || p.uid == uint32(os.Geteuid()) {
+    return nil
+  }
+  scope, ok := commandScopes[cmd]
+  if !ok {
+    // 未知命令交给后面报 unknown command
+    return nil
+  }
+  if !p.allowedLocked(scope, service, namelessCommands[cmd]) {
+    if service != "" {
+      return fmt.Errorf("permission denied: uid %d may not %s %s", p.uid, cmd, service)
+    }
+    return fmt.Errorf("permission denied: uid %d may not %s", p.uid, cmd)
+  }
+  for _, dep := range dependents {
+    if !p.allowedLocked(scope, dep, false) {
+      return fmt.Errorf("permission denied: uid %d may not %s %s, which would also stop %s", p.uid, cmd, service, dep)
+    }
+  }
+  return nil
+}
+
+// allowedLocked 检查是否有规则允许 peer 以 scope 操作 service; 调用方持有 accessMu
+func (p *peer) allowedLocked(scope auth.Scope, service string, nameless bool) bool {
+  for _, r := range accessRules {
+    if !r.uids[p.uid] && !r.matchGroup(p.groups) {
+      continue
+    }
+    if !r.scopes.Allows(scope) {
+      continue
+    }
+    if len(r.services) == 0 || r.services[service] || (nameless && scope == auth.ScopeStatus) {
+      return true
+    }
+  }
+  return false
+}
+
+// statusServices 返回 peer 可以查看的服务名, 用于限制 list 和 events 的输出;
+// 返回 nil 表示不受限制
+func (p *peer) statusServices() []string {
+  accessMu.RLock()
+  defer accessMu.RUnlock()
+  if !accessOn || p.err != nil || p.uid == 0 || p.uid == uint32(os.Geteuid()) {
+    return nil
+  }
+  seen := map[string]bool{}
+  for _, r := range accessRules {
+    if !r.uids[p.uid] && !r.matchGroup(p.groups) {
+      continue
+    }
+    if !r.scopes.Allows(auth.ScopeStatus) {
+      continue
+    }
+    if len(r.services) == 0 {
+      return nil
+    }
+    for s := range r.services {
+      seen[s] = true
+    }
+  }
+  names := make([]string, 0, len(seen))
+  for s := range seen {
+    names = append(names, s)
+  }
+  sort.Strings(names)
+  return names
+}
+
+// filterServices 只保留 allowed 中的服务名; allowed 为 nil 时不限制
+func filterServices(names, allowed []string) []string {
+  if allowed == nil {
+    return names
+  }
+  ok := make(map[string]bool, len(allowed))
+  for _, n := range allowed {
+    ok[n] = true
+  }
+  kept := names[:0]
+  for _, n := range names {
+    if ok[n] {
+      kept = append(kept, n)
+    }
+  }
+  return kept
+}
+
+func (r socketRule) matchGroup(groups map[uint32]bool) bool {
+  for g := range groups {
+    if r.gids[g] {
+      return true
+    }
+  }
+  return false
+}
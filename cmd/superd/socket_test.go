@@ -0,0 +1,123 @@
+package main
+
+import (
+  "reflect"
+  "strings"
+  "testing"
+
+  "github.com/litongjava/supers/internal/auth"
+  "github.com/litongjava/supers/internal/services"
+)
+
+// testPeerUID 是测试中连接方的 uid, 不是 root 也不是运行测试的用户
+const testPeerUID = 4242
+
+// withAccess 在测试期间使用 rules 和 configs
+func withAccess(t *testing.T, rules []socketRule, configs map[string]services.ServiceConfig) {
+  t.Helper()
+  accessMu.Lock()
+  oldRules, oldOn := accessRules, accessOn
+  accessRules, accessOn = rules, true
+  accessMu.Unlock()
+  configMutex.Lock()
+  oldConfigs := serviceConfigs
+  serviceConfigs = configs
+  configMutex.Unlock()
+  t.Cleanup(func() {
+    accessMu.Lock()
+    accessRules, accessOn = oldRules, oldOn
+    accessMu.Unlock()
+    configMutex.Lock()
+    serviceConfigs = oldConfigs
+    configMutex.Unlock()
+  })
+}
+
+func testRule(scopes []auth.Scope, svcs ...string) socketRule {
+  r := socketRule{
+    uids:     map[uint32]bool{testPeerUID: true},
+    gids:     map[uint32]bool{},
+    scopes:   auth.Scopes{},
+    services: map[string]bool{},
+  }
+  for _, s := range scopes {
+    r.scopes[s] = true
+  }
+  for _, s := range svcs {
+    r.services[s] = true
+  }
+  return r
+}
+
+func TestAllow(t *testing.T) {
+  // web Requires=db, worker BindsTo=web
+  configs := map[string]services.ServiceConfig{
+    "db":     {Name: "db"},
+    "web":    {Name: "web", Requires: []string{"db"}, After: []string{"db"}},
+    "worker": {Name: "worker", BindsTo: []string{"web"}, After: []string{"web"}},
+    "cache":  {Name: "cache"},
+  }
+  deploy := []auth.Scope{auth.ScopeDeploy}
+  status := []auth.Scope{auth.ScopeStatus}
+  cases := []struct {
+    name  string
+    rules []socketRule
+    cmd   string
+    args  []string
+    deny  string // 拒绝原因中应包含的内容, 为空表示允许
+  }{
+    {"stop without dependents", []socketRule{testRule(deploy, "cache")}, "stop", []string{"cache"}, ""},
+    {"stop other service", []socketRule{testRule(deploy, "cache")}, "stop", []string{"db"}, "may not stop db"},
+    {"stop cascades to a service without rights", []socketRule{testRule(deploy, "db")}, "stop", []string{"db"}, "also stop"},
+    {"restart cascades to a bound service", []socketRule{testRule(deploy, "db", "web")}, "restart", []string{"db"}, "also stop worker"},
+    {"stop with rights on all dependents", []socketRule{testRule(deploy, "db", "web", "worker")}, "stop", []string{"db"}, ""},
+    {"dependents allowed by another rule", []socketRule{testRule(deploy, "db"), testRule(deploy, "web", "worker")}, "stop", []string{"db"}, ""},
+    {"stop with an unrestricted rule", []socketRule{testRule(deploy)}, "stop", []string{"db"}, ""},
+    {"start does not cascade", []socketRule{testRule(deploy, "db")}, "start", []string{"db"}, ""},
+    {"list with a service list", []socketRule{testRule(status, "web")}, "list", nil, ""},
+    {"events with a service list", []socketRule{testRule(status, "web")}, "events", []string{"-f"}, ""},
+    {"nameless status with a service list", []socketRule{testRule(status, "web")}, "status", nil, "may not status"},
+    {"nameless logs with a service list", []socketRule{testRule(status, "web")}, "logs", nil, "may not logs"},
+    {"nameless status without a service list", []socketRule{testRule(status)}, "status", nil, ""},
+    {"status of an allowed service", []socketRule{testRule(status, "web")}, "status", []string{"web"}, ""},
+    {"missing scope", []socketRule{testRule(status)}, "stop", []string{"web"}, "may not stop web"},
+  }
+  for _, c := range cases {
+    t.Run(c.name, func(t *testing.T) {
+      withAccess(t, c.rules, configs)
+      p := &peer{uid: testPeerUID, gid: testPeerUID, groups: map[uint32]bool{testPeerUID: true}}
+      err := p.allow(c.cmd, c.args)
+      switch {
+      case c.deny == "" && err != nil:
+        t.Errorf("allow(%s %v) = %v, want nil", c.cmd, c.args, err)
+      case c.deny != "" && err == nil:
+        t.Errorf("allow(%s %v) = nil, want an error containing %q", c.cmd, c.args, c.deny)
+      case c.deny != "" && !strings.Contains(err.Error(), c.deny):
+        t.Errorf("allow(%s %v) = %v, want an error containing %q", c.cmd, c.args, err, c.deny)
+      }
+    })
+  }
+}
+
+func TestStatusServices(t *testing.T) {
+  status := []auth.Scope{auth.ScopeStatus}
+  p := &peer{uid: testPeerUID, groups: map[uint32]bool{}}
+
+  // 只有 deploy 的规则不能查看状态
+  withAccess(t, []socketRule{testRule(status, "web"), testRule(status, "db", "cache"), testRule([]auth.Scope{auth.ScopeDeploy}, "api")}, nil)
+  want := []string{"cache", "db", "web"}
+  if got := p.statusServices(); !reflect.DeepEqual(got, want) {
+    t.Errorf("statusServices() = %v, want %v", got, want)
+  }
+  if got := filterServices([]string{"api", "db", "web"}, p.statusServices()); !reflect.DeepEqual(got, []string{"db", "web"}) {
+    t.Errorf("filterServices = %v", got)
+  }
+
+  withAccess(t, []socketRule{testRule(status, "web"), testRule(status)}, nil)
+  if got := p.statusServices(); got != nil {
+    t.Errorf("statusServices() = %v, want nil with an unrestricted rule", got)
+  }
+  if got := filterServices([]string{"api", "web"}, nil); len(got) != 2 {
+    t.Errorf("filterServices with nil = %v", got)
+  }
+}
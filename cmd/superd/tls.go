@@ -0,0 +1,145 @@
+package main
+
+import (
+  "crypto/tls"
+  "crypto/x509"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "sync"
+  "time"
+
+  "github.com/cloudwego/hertz/pkg/common/hlog"
+  "github.com/litongjava/supers/utils"
+)
+
+// certCheckInterval 是检查证书文件是否变化的最小间隔
+const certCheckInterval = 5 * time.Second
+
+// certReloader 在握手时提供 TLS 配置, 证书、私钥或客户端 CA 文件变化后自动重新加载,
+// 续期证书不需要重启 superd. 新文件加载失败时继续使用旧的配置.
+type certReloader struct {
+  cfg utils.TLSConfig
+
+  mu      sync.Mutex
+  checked time.Time
+  stamp   string
+  current *tls.Config
+}
+
+func newCertReloader(cfg utils.TLSConfig) (*certReloader, error) {
+  if cfg.Cert == "" || cfg.Key == "" {
+    return nil, fmt.Errorf("tls: cert and key are required")
+  }
+  switch cfg.ClientAuth {
+  case "", "require", "optional":
+  default:
+    return nil, fmt.Errorf("tls: unknown client_auth %q, use require or optional", cfg.ClientAuth)
+  }
+  r := &certReloader{cfg: cfg}
+  if _, err := r.config(); err != nil {
+    return nil, err
+  }
+  return r, nil
+}
+
+// TLSConfig 返回给 http.Server 使用的配置, 实际的证书由 GetConfigForClient 按需提供
+func (r *certReloader) TLSConfig() *tls.Config {
+  return &tls.Config{
+    MinVersion: tls.VersionTLS12,
+    GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+      return r.config()
+    },
+    // ListenAndServeTLS 要求配置了证书; 实际握手使用 GetConfigForClient 返回的配置
+    GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+      c, err := r.config()
+      if err != nil {
+        return nil, err
+      }
+      return &c.Certificates[0], nil
+    },
+  }
+}
+
+func (r *certReloader) files() []string {
+  files := []string{r.cfg.Cert, r.cfg.Key}
+  if r.cfg.ClientCA != "" {
+    files = append(files, r.cfg.ClientCA)
+  }
+  return files
+}
+
+func (r *certReloader) config() (*tls.Config, error) {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+  now := time.Now()
+  if r.current != nil && now.Sub(r.checked) < certCheckInterval {
+    return r.current, nil
+  }
+  r.checked = now
+
+  stamp, err := fileStamp(r.files())
+  if err == nil && stamp == r.stamp {
+    return r.current, nil
+  }
+  if err == nil {
+    var c *tls.Config
+    if c, err = r.load(); err == nil {
+      if r.current != nil {
+        hlog.Infof("tls: reloaded certificate %s", r.cfg.Cert)
+      }
+      r.current, r.stamp = c, stamp
+      return c, nil
+    }
+    // 同样的文件不再重复加载, 等文件再次变化
+    r.stamp = stamp
+  }
+  if r.current == nil {
+    return nil, err
+  }
+  // 证书更新到一半(例如先写了证书还没写私钥)时继续使用旧的
+  hlog.Errorf("tls: reload failed, keep using the previous certificate: %v", err)
+  return r.current, nil
+}
+
+func (r *certReloader) load() (*tls.Config, error) {
+  cert, err := tls.LoadX509KeyPair(r.cfg.Cert, r.cfg.Key)
+  if err != nil {
+    return nil, fmt.Errorf("tls: load %s: %v", r.cfg.Cert, err)
+  }
+  c := &tls.Config{
+    MinVersion:   tls.VersionTLS12,
+    Certificates: []tls.Certificate{cert},
+    NextProtos:   []string{"http/1.1"},
+  }
+  if r.cfg.ClientCA == "" {
+    return c, nil
+  }
+  pem, err := ioutil.ReadFile(r.cfg.ClientCA)
+  if err != nil {
+    return nil, fmt.Errorf("tls: %v", err)
+  }
+  pool := x509.NewCertPool()
+  if !pool.AppendCertsFromPEM(pem) {
+    return nil, fmt.Errorf("tls: no certificates in %s", r.cfg.ClientCA)
+  }
+  c.ClientCAs = pool
+  c.ClientAuth = tls.RequireAndVerifyClientCert
+  if r.cfg.ClientAuth == "optional" {
+    c.ClientAuth = tls.VerifyClientCertIfGiven
+  }
+  return c, nil
+}
+
+// fileStamp 用大小和修改时间标识文件内容, 任一文件变化时返回值不同
+func fileStamp(files []string) (string, error) {
+  stamp := ""
+  for _, f := range files {
+    fi, err := os.Stat(f)
+    if err != nil {
+      return "", err
+    }
+    stamp += fmt.Sprintf("%s:%d:%d;", f, fi.Size(), fi.ModTime().UnixNano())
+  }
+  return stamp, nil
+}
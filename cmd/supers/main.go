@@ -1,41 +1,350 @@
 package main
 
 import (
+  "encoding/json"
+  "flag"
   "fmt"
-  "io"
   "net"
   "os"
+  "strconv"
+  "time"
+
+  "github.com/litongjava/supers/internal/auth"
+  "github.com/litongjava/supers/internal/logger"
+  "github.com/litongjava/supers/internal/protocol"
+  "gopkg.in/yaml.v2"
 )
 
+const usage = `Usage: supers [-sock path] <command> [args]
+
+  -sock defaults to $SUPERS_SOCK or /var/run/super.sock
+
+Commands:
+  list [-o format]         list all services
+  status [-o format] <name>
+                           show the status of a service
+  start <name>             start a service and its dependencies
+  stop <name>              stop a service and the services requiring it
+  restart <name>           restart a service
+  reload                   reload /etc/super/*.service
+  logs [flags] <name>      show service logs
+  webhooks [-o format]     show webhook delivery status
+  events [flags] [name...] show recent events, optionally only of the named services
+                           (* matches any characters)
+  token <name> <scope>...  generate an HTTP API token (scopes: status, deploy, exec, admin);
+                           runs locally, add the printed entry to app.tokens in config.yml
+
+Output formats of list, status and webhooks:
+  table (default), wide (PID, restarts, last exit, workdir, full command), json, yaml
+
+Flags of logs:
+  -n N                     show the last N lines (default: all, or 10 with -f)
+  -f                       follow new lines (across log rotations)
+  --since T, --until T     RFC3339, "2006-01-02 15:04:05" or a duration such as 10m
+  --stream stdout|stderr   only show one stream
+
+Flags of events:
+  -n N                     show the last N events (default: all kept, or 10 with -f)
+  -f                       follow new events
+  --since T, --until T     same as logs
+  --type T[,T]             only show these event types, e.g. process.exited or 'process.*'
+  --after SEQ              only show events after this sequence number
+  -o json                  one JSON object per line
+`
+
 func main() {
-  if len(os.Args) < 2 {
-    fmt.Println("Usage: supers <cmd> [name]")
+  defaultSock := "/var/run/super.sock"
+  if s := os.Getenv("SUPERS_SOCK"); s != "" {
+    defaultSock = s
+  }
+  sock := flag.String("sock", defaultSock, "superd unix socket")
+  flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+  flag.Parse()
+  if flag.NArg() < 1 {
+    flag.Usage()
+    os.Exit(2)
+  }
+
+  cmd, args := flag.Arg(0), flag.Args()[1:]
+  if cmd == "token" {
+    if err := printToken(args); err != nil {
+      fmt.Fprintln(os.Stderr, "token:", err)
+      os.Exit(2)
+    }
     return
   }
-  cmd := os.Args[1]
-  name := ""
-  if len(os.Args) > 2 {
-    name = os.Args[2]
+  var err error
+  switch cmd {
+  case "logs":
+    args, err = logsRequest(args)
+  case "events":
+    args, err = eventsRequest(args)
+  case "list", "status", "webhooks":
+    args, err = statusRequest(cmd, args)
+  }
+  if err != nil {
+    fmt.Fprintln(os.Stderr, err)
+    os.Exit(2)
   }
 
-  conn, err := net.Dial("unix", "/var/run/super.sock")
+  conn, err := net.Dial("unix", *sock)
   if err != nil {
     fmt.Println("connect error:", err)
-    return
+    os.Exit(1)
   }
   defer conn.Close()
 
-  req := cmd
-  if name != "" {
-    req += " " + name
-  }
-  if _, err := conn.Write([]byte(req)); err != nil {
+  req := protocol.Request{V: protocol.Version, ID: "1", Cmd: cmd, Args: args}
+  data, _ := json.Marshal(req)
+  if _, err := conn.Write(append(data, '\n')); err != nil {
     fmt.Println("write error:", err)
-    return
+    os.Exit(1)
   }
 
-  // 方案 A：直接拷贝到 stdout（一直读到 EOF）
-  if _, err := io.Copy(os.Stdout, conn); err != nil && err != io.EOF {
-    fmt.Println("read error:", err)
+  dec := json.NewDecoder(conn)
+  for {
+    var resp protocol.Response
+    if err := dec.Decode(&resp); err != nil {
+      fmt.Println("read error:", err)
+      os.Exit(1)
+    }
+    if err := render(cmd, resp); err != nil {
+      fmt.Fprintln(os.Stderr, "error:", err)
+      os.Exit(1)
+    }
+    if !resp.More {
+      return
+    }
+  }
+}
+
+// render 按命令把响应输出为文本; 失败的响应返回错误
+func render(cmd string, resp protocol.Response) error {
+  switch cmd {
+  case "list":
+    var list []protocol.ServiceStatus
+    if resp.OK {
+      if err := json.Unmarshal(resp.Result, &list); err != nil {
+        return err
+      }
+      if err := printStatuses(os.Stdout, list, outputFormat, false); err != nil {
+        return err
+      }
+    }
+  case "status":
+    var st protocol.ServiceStatus
+    if resp.OK {
+      if err := json.Unmarshal(resp.Result, &st); err != nil {
+        return err
+      }
+      if err := printStatuses(os.Stdout, []protocol.ServiceStatus{st}, outputFormat, true); err != nil {
+        return err
+      }
+    }
+  case "start", "stop", "restart":
+    var result protocol.ActionsResult
+    if len(resp.Result) > 0 {
+      if err := json.Unmarshal(resp.Result, &result); err != nil {
+        return err
+      }
+    }
+    for _, a := range result.Actions {
+      fmt.Println(a.String())
+    }
+    if !resp.OK && len(result.Actions) > 0 {
+      // 失败的操作已经逐行输出
+      os.Exit(1)
+    }
+  case "reload":
+    if resp.OK {
+      fmt.Println("reloaded")
+    }
+  case "webhooks":
+    var list []protocol.WebhookStatus
+    if resp.OK {
+      if err := json.Unmarshal(resp.Result, &list); err != nil {
+        return err
+      }
+      if err := printWebhooks(os.Stdout, list, outputFormat); err != nil {
+        return err
+      }
+    }
+  case "events":
+    if resp.More {
+      var e protocol.Event
+      if err := json.Unmarshal(resp.Result, &e); err != nil {
+        return err
+      }
+      if err := printEvent(os.Stdout, e, outputFormat); err != nil {
+        return err
+      }
+    }
+  case "logs":
+    if resp.More {
+      var line protocol.LogLine
+      if err := json.Unmarshal(resp.Result, &line); err != nil {
+        return err
+      }
+      fmt.Println(line.Line)
+    }
+  default:
+    if len(resp.Result) > 0 {
+      fmt.Println(string(resp.Result))
+    }
+  }
+  if resp.Error != nil {
+    return resp.Error
+  }
+  return nil
+}
+
+// printToken 生成一个随机 token, 输出明文以及 config.yml 中对应的配置; 明文只在这里出现一次
+func printToken(args []string) error {
+  if len(args) < 2 {
+    return fmt.Errorf("usage: supers token <name> <scope>...")
+  }
+  token, err := auth.NewToken()
+  if err != nil {
+    return err
+  }
+  cfg := auth.TokenConfig{Name: args[0], Hash: auth.Hash(token), Scopes: args[1:]}
+  if err := auth.Validate(cfg); err != nil {
+    return err
+  }
+  data, err := yaml.Marshal([]auth.TokenConfig{cfg})
+  if err != nil {
+    return err
+  }
+  fmt.Printf("token: %s\n\n# add to app.tokens in config.yml:\n%s", token, data)
+  return nil
+}
+
+// outputFormat 是 list / status 的 -o 参数
+var outputFormat = outputTable
+
+// parseInterspersed 解析可以写在位置参数前后的 flag, 返回位置参数
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+  var positional []string
+  for {
+    if err := fs.Parse(args); err != nil {
+      return nil, err
+    }
+    if fs.NArg() == 0 {
+      return positional, nil
+    }
+    positional = append(positional, fs.Arg(0))
+    args = fs.Args()[1:]
+  }
+}
+
+// statusRequest 解析 list / status / webhooks 的参数, 返回发给 superd 的 args
+func statusRequest(cmd string, args []string) ([]string, error) {
+  fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+  fs.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+  fs.StringVar(&outputFormat, "o", outputTable, "")
+  names, err := parseInterspersed(fs, args)
+  if err != nil {
+    return nil, err
+  }
+  if !validOutput(outputFormat) {
+    return nil, fmt.Errorf("%s: unknown output format %q", cmd, outputFormat)
+  }
+  if cmd == "status" && len(names) != 1 {
+    return nil, fmt.Errorf("status: exactly one service name is required")
+  }
+  if cmd != "status" && len(names) != 0 {
+    return nil, fmt.Errorf("%s: unexpected argument %q", cmd, names[0])
+  }
+  return names, nil
+}
+
+// logsRequest 解析 logs 的参数, 返回发给 superd 的 args; 服务名可以写在参数前或后,
+// --since/--until 在本地转换为 RFC3339, 相对时间以客户端的当前时间为准
+func logsRequest(args []string) ([]string, error) {
+  fs := flag.NewFlagSet("logs", flag.ContinueOnError)
+  fs.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+  tail := fs.Int("n", -1, "")
+  follow := fs.Bool("f", false, "")
+  since := fs.String("since", "", "")
+  until := fs.String("until", "", "")
+  stream := fs.String("stream", "", "")
+
+  names, err := parseInterspersed(fs, args)
+  if err != nil {
+    return nil, err
+  }
+  if len(names) != 1 {
+    return nil, fmt.Errorf("logs: exactly one service name is required")
+  }
+
+  var req []string
+  if *tail >= 0 {
+    req = append(req, "-n", strconv.Itoa(*tail))
+  }
+  if *follow {
+    req = append(req, "-f")
+  }
+  now := time.Now()
+  for _, t := range []struct{ flag, value string }{{"-since", *since}, {"-until", *until}} {
+    if t.value == "" {
+      continue
+    }
+    parsed, err := logger.ParseTime(t.value, now)
+    if err != nil {
+      return nil, fmt.Errorf("logs: %s: %v", t.flag, err)
+    }
+    req = append(req, t.flag, parsed.Format(time.RFC3339Nano))
+  }
+  if *stream != "" {
+    req = append(req, "-stream", *stream)
+  }
+  return append(req, names[0]), nil
+}
+
+// eventsRequest 解析 events 的参数, 返回发给 superd 的 args; 与 logs 一样,
+// --since/--until 在本地转换为 RFC3339
+func eventsRequest(args []string) ([]string, error) {
+  fs := flag.NewFlagSet("events", flag.ContinueOnError)
+  fs.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+  limit := fs.Int("n", -1, "")
+  follow := fs.Bool("f", false, "")
+  since := fs.String("since", "", "")
+  until := fs.String("until", "", "")
+  types := fs.String("type", "", "")
+  after := fs.Uint64("after", 0, "")
+  fs.StringVar(&outputFormat, "o", outputTable, "")
+
+  names, err := parseInterspersed(fs, args)
+  if err != nil {
+    return nil, err
+  }
+  if outputFormat != outputTable && outputFormat != outputJSON {
+    return nil, fmt.Errorf("events: unknown output format %q, use table or json", outputFormat)
+  }
+
+  var req []string
+  if *limit >= 0 {
+    req = append(req, "-n", strconv.Itoa(*limit))
+  }
+  if *follow {
+    req = append(req, "-f")
+  }
+  now := time.Now()
+  for _, t := range []struct{ flag, value string }{{"-since", *since}, {"-until", *until}} {
+    if t.value == "" {
+      continue
+    }
+    parsed, err := logger.ParseTime(t.value, now)
+    if err != nil {
+      return nil, fmt.Errorf("events: %s: %v", t.flag, err)
+    }
+    req = append(req, t.flag, parsed.Format(time.RFC3339Nano))
+  }
+  if *types != "" {
+    req = append(req, "-type", *types)
+  }
+  if *after > 0 {
+    req = append(req, "-after", strconv.FormatUint(*after, 10))
   }
+  return append(req, names...), nil
 }
@@ -0,0 +1,189 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "io"
+  "strconv"
+  "strings"
+  "text/tabwriter"
+  "time"
+  "unicode/utf8"
+
+  "github.com/litongjava/supers/internal/protocol"
+  "gopkg.in/yaml.v2"
+)
+
+// list / status 的 -o 取值
+const (
+  outputTable = "table"
+  outputWide  = "wide"
+  outputJSON  = "json"
+  outputYAML  = "yaml"
+)
+
+// 普通表格中命令行的最大显示长度(字符数), -o wide 不截断
+const commandWidth = 40
+
+func validOutput(o string) bool {
+  switch o {
+  case outputTable, outputWide, outputJSON, outputYAML:
+    return true
+  }
+  return false
+}
+
+// printStatuses 按 -o 指定的格式输出服务状态; single 为 true 时 json/yaml 输出单个对象而不是数组
+func printStatuses(w io.Writer, list []protocol.ServiceStatus, format string, single bool) error {
+  var v interface{} = list
+  if single && len(list) == 1 {
+    v = list[0]
+  }
+  switch format {
+  case outputJSON:
+    enc := json.NewEncoder(w)
+    enc.SetIndent("", "  ")
+    return enc.Encode(v)
+  case outputYAML:
+    data, err := yaml.Marshal(v)
+    if err != nil {
+      return err
+    }
+    _, err = w.Write(data)
+    return err
+  }
+
+  tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+  if format == outputWide {
+    fmt.Fprintln(tw, "NAME\tSTATE\tPID\tUPTIME\tRESTARTS\tLAST EXIT\tWORKDIR\tCOMMAND")
+  } else {
+    fmt.Fprintln(tw, "NAME\tSTATE\tUPTIME\tCOMMAND")
+  }
+  for _, st := range list {
+    command := strings.Join(st.Command, " ")
+    if format == outputWide {
+      fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+        st.Name, st.State, orDash(pidString(st.PID)), orDash(uptime(st)), st.Restarts,
+        orDash(lastExit(st.LastExit)), orDash(st.WorkingDirectory), command)
+      continue
+    }
+    if utf8.RuneCountInString(command) > commandWidth {
+      command = string([]rune(command)[:commandWidth-1]) + "…"
+    }
+    fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", st.Name, st.State, orDash(uptime(st)), command)
+  }
+  return tw.Flush()
+}
+
+// printWebhooks 输出 webhook 投递状态; -o wide 与 table 相同
+func printWebhooks(w io.Writer, list []protocol.WebhookStatus, format string) error {
+  switch format {
+  case outputJSON:
+    enc := json.NewEncoder(w)
+    enc.SetIndent("", "  ")
+    return enc.Encode(list)
+  case outputYAML:
+    data, err := yaml.Marshal(list)
+    if err != nil {
+      return err
+    }
+    _, err = w.Write(data)
+    return err
+  }
+
+  tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+  fmt.Fprintln(tw, "URL\tPENDING\tDELIVERED\tFAILED\tDROPPED\tLAST SUCCESS\tLAST ERROR")
+  for _, st := range list {
+    lastSuccess := ""
+    if st.LastSuccess != nil {
+      lastSuccess = st.LastSuccess.Local().Format("2006-01-02 15:04:05")
+    }
+    lastError := st.LastError
+    if st.NextRetry != nil {
+      lastError += fmt.Sprintf(" (attempt %d, retry at %s)", st.Attempts, st.NextRetry.Local().Format("15:04:05"))
+    }
+    fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%s\t%s\n", st.URL, st.Pending, st.Delivered, st.Failed, st.Dropped,
+      orDash(lastSuccess), orDash(lastError))
+  }
+  return tw.Flush()
+}
+
+func pidString(pid int) string {
+  if pid == 0 {
+    return ""
+  }
+  return strconv.Itoa(pid)
+}
+
+func uptime(st protocol.ServiceStatus) string {
+  if st.StartedAt == nil {
+    return ""
+  }
+  d := time.Duration(st.UptimeSec) * time.Second
+  switch {
+  case d >= 24*time.Hour:
+    return fmt.Sprintf("%dd%dh", int(d.Hours())/24, int(d.Hours())%24)
+  case d >= time.Hour:
+    return fmt.Sprintf("%dh%dm", int(d.Hours()), int(d.Minutes())%60)
+  case d >= time.Minute:
+    return fmt.Sprintf("%dm%ds", int(d.Minutes()), int(d.Seconds())%60)
+  }
+  return fmt.Sprintf("%ds", int(d.Seconds()))
+}
+
+func lastExit(e *protocol.LastExit) string {
+  if e == nil {
+    return ""
+  }
+  if e.Signal != "" {
+    return e.Signal
+  }
+  return strconv.Itoa(e.Code)
+}
+
+func orDash(s string) string {
+  if s == "" {
+    return "-"
+  }
+  return s
+}
+
+// printEvent 输出 events 的一个事件: 默认一行文本, 失败事件的日志缩进列在后面; -o json 每行一个 JSON
+func printEvent(w io.Writer, e protocol.Event, format string) error {
+  if format == outputJSON {
+    return json.NewEncoder(w).Encode(e)
+  }
+  var details []string
+  add := func(key, value string) {
+    if value != "" {
+      details = append(details, key+"="+value)
+    }
+  }
+  add("pid", pidString(e.PID))
+  if e.Type == protocol.EventProcessExited || e.Type == protocol.EventProcessGaveUp || e.Type == protocol.EventProcessRestarted {
+    add("exit", strconv.Itoa(e.ExitCode))
+  }
+  add("signal", e.Signal)
+  if e.CoreDumped {
+    add("core", "dumped")
+  }
+  if e.UptimeSec > 0 {
+    add("uptime", time.Duration(e.UptimeSec*float64(time.Second)).Round(time.Millisecond).String())
+  }
+  if e.Restarts > 0 {
+    add("restarts", strconv.Itoa(e.Restarts))
+  }
+  add("stop", e.StopResult)
+  if e.State != "" {
+    add("state", e.PrevState+"->"+e.State)
+  }
+  add("error", e.Error)
+  _, err := fmt.Fprintf(w, "%s #%d %s %s %s\n", e.Time.Local().Format("2006-01-02 15:04:05.000"), e.Seq, e.Name, e.Type,
+    strings.Join(details, " "))
+  for _, line := range e.LogTail {
+    if _, err := fmt.Fprintf(w, "    | %s\n", line); err != nil {
+      return err
+    }
+  }
+  return err
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/cloudwego/hertz/pkg/common/hlog"
 	"github.com/google/uuid"
+	"github.com/litongjava/supers/internal/auth"
 	"github.com/litongjava/supers/utils"
 	"io"
 	"io/ioutil"
@@ -13,20 +14,23 @@ import (
 	"time"
 )
 
+// defaultUploadDir 是 config.yml 中没有 app.filePath 时上传文件的目录
+const defaultUploadDir = "/data/upload"
+
+func uploadDir() string {
+	if app := utils.CurrentConfig().App; app != nil && app.FilePath != "" {
+		return app.FilePath
+	}
+	return defaultUploadDir
+}
+
 func RegisterFileRouter() {
-	http.HandleFunc("/deploy/file/upload", handleUpload)
-	http.HandleFunc("/deploy/file/download/", handleDownload)
+	http.HandleFunc("/deploy/file/upload", auth.Require(auth.ScopeDeploy, handleUpload))
+	http.HandleFunc("/deploy/file/download/", auth.Require(auth.ScopeDeploy, handleDownload))
 }
 
 // 上传文件
 func handleUpload(writer http.ResponseWriter, request *http.Request) {
-	//验证密码
-	var password = request.FormValue("p")
-	if password != utils.CONFIG.App.Password {
-		http.Error(writer, "passowrd is not correct", http.StatusBadRequest)
-		return
-	}
-
 	file, header, err := request.FormFile("file")
 	if err != nil {
 		return
@@ -36,7 +40,7 @@ func handleUpload(writer http.ResponseWriter, request *http.Request) {
 	dateString := timeNow.Format("2006-01-02")
 	uuidString := uuid.New().String()
 
-	savePath := utils.CONFIG.App.FilePath + "/" + dateString + "/" + uuidString
+	savePath := uploadDir() + "/" + dateString + "/" + uuidString
 	isExists := IsExist(savePath)
 	if !isExists {
 		hlog.Info("create path", savePath)
@@ -61,20 +65,13 @@ func handleUpload(writer http.ResponseWriter, request *http.Request) {
 
 // 下载文件
 func handleDownload(writer http.ResponseWriter, request *http.Request) {
-	//验证密码
-	var password = request.FormValue("p")
-	if password != utils.CONFIG.App.Password {
-		http.Error(writer, "passowrd is not correct", http.StatusBadRequest)
-		return
-	}
-
 	subDir, done := getId(writer, request)
 	if done {
 		return
 	}
 
 	hlog.Info("subDir:", subDir)
-	savePath := utils.CONFIG.App.FilePath + "/" + subDir
+	savePath := uploadDir() + "/" + subDir
 	filename, done := getFilename(writer, savePath)
 	if done {
 		return
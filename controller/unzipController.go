@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/litongjava/supers/internal/auth"
 	"github.com/litongjava/supers/services"
 	"github.com/litongjava/supers/utils"
 	"net/http"
@@ -12,8 +13,9 @@ import (
 )
 
 func RegisterUnzipRouter() {
-	http.HandleFunc("/deploy/file/upload-unzip/", handleUploadUnzip)
-	http.HandleFunc("/deploy/file/upload-run/", handleUploadRun)
+	http.HandleFunc("/deploy/file/upload-unzip/", auth.Require(auth.ScopeDeploy, handleUploadUnzip))
+	// upload-run 会执行上传的脚本
+	http.HandleFunc("/deploy/file/upload-run/", auth.Require(auth.ScopeExec, handleUploadRun))
 }
 
 // 上传文件,放到指定目录,并运行脚本
@@ -23,13 +25,6 @@ func handleUploadRun(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	//验证密码
-	var password = r.FormValue("p")
-	if password != utils.CONFIG.App.Password {
-		hlog.Info("the correct password is :", utils.CONFIG.App.Password)
-		http.Error(w, "passowrd is not correct", http.StatusUnauthorized)
-		return
-	}
 	file, header, err := r.FormFile("file")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -238,12 +233,6 @@ func handleUploadUnzip(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	//验证密码
-	var password = r.FormValue("p")
-	if password != utils.CONFIG.App.Password {
-		http.Error(w, "passowrd is not correct", http.StatusBadRequest)
-		return
-	}
 	file, _, err := r.FormFile("file")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
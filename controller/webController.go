@@ -4,25 +4,20 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"github.com/litongjava/supers/internal/auth"
 	"github.com/litongjava/supers/services"
-	"github.com/litongjava/supers/utils"
 	"log"
 	"net/http"
 	"regexp"
 )
 
 func RegisterWebRouter() {
-	http.HandleFunc("/deploy/web/", handleWeb)
+	// /deploy/web/ 执行任意命令
+	http.HandleFunc("/deploy/web/", auth.Require(auth.ScopeExec, handleWeb))
 }
 
 func handleWeb(writer http.ResponseWriter, request *http.Request) {
 	//log.Println(request.URL.Path)
-	//验证密码
-	var password = request.FormValue("p")
-	if password != utils.CONFIG.App.Password {
-		http.Error(writer, "passowrd is not correct", http.StatusBadRequest)
-		return
-	}
 	pattern, _ := regexp.Compile(`/web/(.+)`)
 	matches := pattern.FindStringSubmatch(request.URL.Path)
 	if len(matches) > 0 {
@@ -0,0 +1,297 @@
+// Package auth 校验 HTTP 接口的 API token 和客户端证书.
+//
+// token 以 SHA-256 摘要的形式配置在 config.yml 的 app.tokens 中, 请求通过
+// "Authorization: Bearer <token>" 携带明文 token. 启用双向 TLS 时, 已验证的客户端证书
+// 按 CN / SAN 匹配 app.tls.clients. 每个 token 或证书身份有自己的 scope,
+// 只能访问 scope 允许的接口.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+// Scope 限定 token 可以访问的接口
+type Scope string
+
+const (
+	ScopeStatus Scope = "status" // 只读: 服务列表、状态、日志、unit 文件
+	ScopeDeploy Scope = "deploy" // 上传/下载文件, 启动/停止/重启服务
+	ScopeExec   Scope = "exec"   // 执行命令和上传后运行的脚本
+	ScopeAdmin  Scope = "admin"  // 全部接口, 包括修改 unit 文件和 reload
+)
+
+// hashPrefix 是配置中摘要的前缀, 为以后更换算法留出余地
+const hashPrefix = "sha256:"
+
+var (
+	// ErrNoCredentials 表示请求没有携带 token
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidToken 表示 token 与任何配置的 token 都不匹配
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// TokenConfig 是 config.yml 中 app.tokens 的一项. Hash 为 "sha256:" 加上 token 的
+// SHA-256 十六进制摘要, 可以用 supers token 生成
+type TokenConfig struct {
+	Name   string   `yaml:"name"`
+	Hash   string   `yaml:"hash"`
+	Scopes []string `yaml:"scopes"` // status / deploy / exec / admin
+}
+
+// ClientConfig 是 config.yml 中 app.tls.clients 的一项: 证书的 CN、DNS / email / URI SAN
+// 之一等于 Name 时, 该证书具有 Scopes
+type ClientConfig struct {
+	Name   string   `yaml:"name"`
+	Scopes []string `yaml:"scopes"`
+}
+
+// Config 是认证相关的全部配置
+type Config struct {
+	Tokens  []TokenConfig
+	Clients []ClientConfig
+	// Password 是旧的 ?p= 密码, 为空时不接受
+	Password string
+}
+
+// Scopes 是一组 scope
+type Scopes map[Scope]bool
+
+// Allows reports whether the set grants scope; admin implies every other scope.
+func (s Scopes) Allows(scope Scope) bool {
+	return s[ScopeAdmin] || s[scope]
+}
+
+// ParseScopes 解析配置中的 scope 列表
+func ParseScopes(list []string) (Scopes, error) {
+	if len(list) == 0 {
+		return nil, errors.New("no scopes")
+	}
+	scopes := make(Scopes, len(list))
+	for _, s := range list {
+		switch Scope(s) {
+		case ScopeStatus, ScopeDeploy, ScopeExec, ScopeAdmin:
+			scopes[Scope(s)] = true
+		default:
+			return nil, fmt.Errorf("unknown scope %q", s)
+		}
+	}
+	return scopes, nil
+}
+
+// Token 是一个已配置的 API token, 或一个客户端证书身份
+type Token struct {
+	Name   string
+	hash   []byte
+	scopes Scopes
+}
+
+// Allows reports whether the token may access endpoints that require scope.
+func (t *Token) Allows(scope Scope) bool {
+	return t.scopes.Allows(scope)
+}
+
+var (
+	mu       sync.RWMutex
+	tokens   []*Token
+	clients  map[string]*Token // 证书身份 -> scope
+	password []byte            // 兼容旧的 ?p= 密码, 视为 admin
+
+	legacyWarning sync.Once
+)
+
+// Configure 替换当前的 token、证书身份和旧密码; 配置有误的项被忽略并返回错误
+func Configure(c Config) error {
+	var list []*Token
+	var errs []string
+	for i, tc := range c.Tokens {
+		t, err := parseToken(tc)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("tokens[%d] %s: %v", i, tc.Name, err))
+			continue
+		}
+		list = append(list, t)
+	}
+	identities := make(map[string]*Token, len(c.Clients))
+	for i, cc := range c.Clients {
+		if cc.Name == "" {
+			errs = append(errs, fmt.Sprintf("tls.clients[%d]: no name", i))
+			continue
+		}
+		scopes, err := ParseScopes(cc.Scopes)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("tls.clients[%d] %s: %v", i, cc.Name, err))
+			continue
+		}
+		identities[cc.Name] = &Token{Name: "cert:" + cc.Name, scopes: scopes}
+	}
+	var legacy []byte
+	if c.Password != "" {
+		sum := sha256.Sum256([]byte(c.Password))
+		legacy = sum[:]
+	}
+
+	mu.Lock()
+	tokens, clients, password = list, identities, legacy
+	mu.Unlock()
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Validate 检查一项 token 配置
+func Validate(c TokenConfig) error {
+	_, err := parseToken(c)
+	return err
+}
+
+func parseToken(c TokenConfig) (*Token, error) {
+	if c.Name == "" {
+		return nil, errors.New("no name")
+	}
+	if !strings.HasPrefix(c.Hash, hashPrefix) {
+		return nil, fmt.Errorf("hash must start with %q", hashPrefix)
+	}
+	hash, err := hex.DecodeString(strings.TrimPrefix(c.Hash, hashPrefix))
+	if err != nil || len(hash) != sha256.Size {
+		return nil, errors.New("hash is not a hex SHA-256 digest")
+	}
+	scopes, err := ParseScopes(c.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	return &Token{Name: c.Name, hash: hash, scopes: scopes}, nil
+}
+
+// Hash 返回 token 在配置文件中的写法
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hashPrefix + hex.EncodeToString(sum[:])
+}
+
+// NewToken 生成一个随机 token
+func NewToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Authenticate 从请求中取出 token 并校验.
+// 依次使用 Authorization: Bearer、已验证的客户端证书、旧的 ?p= 密码(仅在配置了 app.password 时).
+func Authenticate(r *http.Request) (*Token, error) {
+	var presented string
+	legacy := false
+	h := r.Header.Get("Authorization")
+	if h == "" && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		t, err := fromCertificate(r.TLS.VerifiedChains[0][0])
+		if err == nil {
+			return t, nil
+		}
+		if legacyPassword(r) == "" {
+			return nil, err
+		}
+	}
+	if h != "" {
+		const prefix = "bearer "
+		if len(h) <= len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+			return nil, ErrInvalidToken
+		}
+		presented = strings.TrimSpace(h[len(prefix):])
+	} else if p := legacyPassword(r); p != "" {
+		presented, legacy = p, true
+	} else {
+		return nil, ErrNoCredentials
+	}
+
+	// 比较摘要而不是明文, 长度固定, 且逐个比较全部 token, 耗时与匹配的位置无关
+	sum := sha256.Sum256([]byte(presented))
+	mu.RLock()
+	defer mu.RUnlock()
+	var found *Token
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare(sum[:], t.hash) == 1 && found == nil {
+			found = t
+		}
+	}
+	if found != nil {
+		return found, nil
+	}
+	if legacy && password != nil && subtle.ConstantTimeCompare(sum[:], password) == 1 {
+		legacyWarning.Do(func() {
+			hlog.Warnf("auth: a client authenticated with app.password (?p=); use app.tokens and Authorization: Bearer instead")
+		})
+		return &Token{Name: "password", scopes: Scopes{ScopeAdmin: true}}, nil
+	}
+	return nil, ErrInvalidToken
+}
+
+// fromCertificate 按证书的 CN 和 SAN 查找配置的身份
+func fromCertificate(cert *x509.Certificate) (*Token, error) {
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, name := range names {
+		if t, ok := clients[name]; ok && name != "" {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("client certificate %q is not in tls.clients", cert.Subject.CommonName)
+}
+
+// legacyPassword 取出旧客户端放在 URL 或 POST 表单中的 p.
+// PUT 的请求体是 unit 文件内容, 不能当作表单解析.
+func legacyPassword(r *http.Request) string {
+	if p := r.URL.Query().Get("p"); p != "" {
+		return p
+	}
+	if r.Method == http.MethodPost {
+		return r.PostFormValue("p")
+	}
+	return ""
+}
+
+// Check 校验请求是否可以访问需要 scope 的接口; 失败时返回应答的 HTTP 状态码
+func Check(r *http.Request, scope Scope) (*Token, int, error) {
+	t, err := Authenticate(r)
+	if err != nil {
+		hlog.Warnf("auth: %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+		return nil, http.StatusUnauthorized, err
+	}
+	if !t.Allows(scope) {
+		hlog.Warnf("auth: token %s is not allowed to %s %s (requires %s)", t.Name, r.Method, r.URL.Path, scope)
+		return t, http.StatusForbidden, fmt.Errorf("token %s does not have scope %s", t.Name, scope)
+	}
+	return t, 0, nil
+}
+
+// Require 是 HTTP 中间件: 只有带有 scope 的 token 才能访问 h
+func Require(scope Scope, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, status, err := Check(r, scope); err != nil {
+			if status == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="supers"`)
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		h(w, r)
+	}
+}
@@ -1,14 +1,20 @@
 package events
 
 import (
+  "os"
   "strconv"
   "sync"
+  "sync/atomic"
+  "time"
+
+  "github.com/google/uuid"
 )
 
 // ------------- 全局处理器
 var (
-  hMu      sync.RWMutex
-  handlers []Handler
+  hMu          sync.RWMutex
+  handlers     []Handler
+  syncHandlers []Handler
 )
 
 // Register adds a new Handler (global, for logging/audit etc).
@@ -18,6 +24,14 @@ func Register(h Handler) {
   hMu.Unlock()
 }
 
+// RegisterSync adds a Handler that Emit calls synchronously, one event at a time
+// in Seq order. 用于事件历史这类需要保持顺序的 handler, Handle 必须很快返回.
+func RegisterSync(h Handler) {
+  hMu.Lock()
+  syncHandlers = append(syncHandlers, h)
+  hMu.Unlock()
+}
+
 // ------------- 基于 name+type 的一次性订阅 -------------
 
 // key: name + "#" + type
@@ -44,14 +58,55 @@ func SubscribeOnce(name string, t EventType) <-chan Event {
 
 // ------------- Emit 分发（同时支持全局 Handler 与一次性订阅） -------------
 
+var (
+  // emitMu 使 Seq 的分配与同步 handler 的调用顺序一致
+  emitMu   sync.Mutex
+  seq      uint64
+  hostname = func() string {
+    h, _ := os.Hostname()
+    return h
+  }()
+)
+
+// resumeSeq 使之后的 Seq 从 n 之后开始, 用于从历史文件恢复
+func resumeSeq(n uint64) {
+  for {
+    cur := atomic.LoadUint64(&seq)
+    if cur >= n || atomic.CompareAndSwapUint64(&seq, cur, n) {
+      return
+    }
+  }
+}
+
 // Emit dispatches the Event to all registered handlers and notifies subscribers.
+// 未填写的 ID、Seq、Time、Host 在这里补上.
 func Emit(e Event) {
   // 1) 复制 handlers 快照，避免持锁执行用户代码
   hMu.RLock()
   hcopy := make([]Handler, len(handlers))
   copy(hcopy, handlers)
+  scopy := make([]Handler, len(syncHandlers))
+  copy(scopy, syncHandlers)
   hMu.RUnlock()
 
+  emitMu.Lock()
+  if e.ID == "" {
+    e.ID = uuid.NewString()
+  }
+  if e.Seq == 0 {
+    e.Seq = atomic.AddUint64(&seq, 1)
+  }
+  if e.Time.IsZero() {
+    e.Time = time.Now()
+  }
+  if e.Host == "" {
+    e.Host = hostname
+  }
+  for _, h := range scopy {
+    h.Handle(e)
+  }
+  emitMu.Unlock()
+
   // 2) 触发一次性订阅者（只触发一次就移除）
   k := key(e.Name, e.Type)
   var toNotify []chan Event
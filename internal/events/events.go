@@ -1,23 +1,22 @@
 package events
 
-// EventType represents a type of event emitted by superd.
-type EventType string
+import "github.com/litongjava/supers/internal/protocol"
 
-const (
-  EventProcessStarted     EventType = "process.started"
-  EventProcessStartFailed EventType = "process.start_failed"
-  EventProcessExited      EventType = "process.exited"
-  EventProcessRestarted   EventType = "process.restarted"
+// Event 和 EventType 定义在 internal/protocol 中, supers 解析事件时不需要链接 superd 的代码
+type (
+  Event     = protocol.Event
+  EventType = protocol.EventType
 )
 
-// Event carries information about a process event.
-type Event struct {
-  Name     string    `json:"name"`
-  Type     EventType `json:"type"`
-  ExitCode int       `json:"exit_code,omitempty"`
-  PID      int       `json:"pid,omitempty"`
-  Error    string    `json:"error,omitempty"`
-}
+const (
+  EventProcessStarted      = protocol.EventProcessStarted
+  EventProcessStartFailed  = protocol.EventProcessStartFailed
+  EventProcessExited       = protocol.EventProcessExited
+  EventProcessRestarted    = protocol.EventProcessRestarted
+  EventProcessGaveUp       = protocol.EventProcessGaveUp
+  EventProcessAdopted      = protocol.EventProcessAdopted
+  EventProcessStateChanged = protocol.EventProcessStateChanged
+)
 
 // Handler defines how to consume an Event.
 type Handler interface {
@@ -0,0 +1,267 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+
+	"github.com/litongjava/supers/utils"
+)
+
+const (
+	// DefaultExecTimeout 是 events.exec 命令的默认超时
+	DefaultExecTimeout = 30 * time.Second
+
+	// execQueueSize 是每个命令最多排队的事件数, 超出时丢弃新事件
+	execQueueSize = 100
+	// execOutputMax 是每次执行最多记入日志的输出字节数
+	execOutputMax = 64 << 10
+	// execLineMax 之后没有换行符的输出也作为一行写出
+	execLineMax = 4 << 10
+)
+
+// ExecHandler runs local commands for matching events.
+// 每个命令有自己的队列和 Concurrency 个 goroutine; 命令超时后整个进程组被杀掉,
+// 输出逐行记入 superd 的日志.
+type ExecHandler struct {
+	mu    sync.Mutex
+	hooks []*execHook
+}
+
+// NewExecHandler creates a handler using the config.
+func NewExecHandler() *ExecHandler {
+	h := &ExecHandler{}
+	if err := h.Configure(utils.CurrentConfig().Events); err != nil {
+		hlog.Errorf("exec: %v", err)
+	}
+	return h
+}
+
+// Configure 替换全部命令; 旧命令已排队的事件仍按旧配置执行完. 配置有误的项被跳过并返回错误
+func (h *ExecHandler) Configure(cfg *utils.EventsConfig) error {
+	var list []utils.ExecHookConfig
+	if cfg != nil {
+		list = cfg.Exec
+	}
+	var hooks []*execHook
+	var errs []string
+	for i, c := range list {
+		k, err := newExecHook(c)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("exec[%d]: %v", i, err))
+			continue
+		}
+		hooks = append(hooks, k)
+	}
+
+	h.mu.Lock()
+	old := h.hooks
+	h.hooks = hooks
+	h.mu.Unlock()
+	for _, k := range old {
+		close(k.queue)
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Handle 把事件交给每个匹配的命令; 命令的队列满时丢弃
+func (h *ExecHandler) Handle(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, k := range h.hooks {
+		if !matchAny(k.events, string(e.Type)) || !matchAny(k.services, e.Name) {
+			continue
+		}
+		select {
+		case k.queue <- e:
+		default:
+			hlog.Warnf("exec %s: too many pending runs, dropping %s %s (seq %d)", k.name, e.Name, e.Type, e.Seq)
+		}
+	}
+}
+
+// execHook 是解析后的 utils.ExecHookConfig
+type execHook struct {
+	name     string
+	argv     []string
+	events   []string
+	services []string
+	timeout  time.Duration
+	queue    chan Event
+}
+
+func newExecHook(c utils.ExecHookConfig) (*execHook, error) {
+	if len(c.Command) == 0 || c.Command[0] == "" {
+		return nil, errors.New("no command")
+	}
+	if !filepath.IsAbs(c.Command[0]) {
+		if _, err := exec.LookPath(c.Command[0]); err != nil {
+			return nil, err
+		}
+	}
+	if err := validPatterns(c.Events, c.Services); err != nil {
+		return nil, err
+	}
+	k := &execHook{
+		name:     c.Name,
+		argv:     c.Command,
+		events:   c.Events,
+		services: c.Services,
+		timeout:  c.Timeout,
+		queue:    make(chan Event, execQueueSize),
+	}
+	if k.name == "" {
+		k.name = strings.Join(c.Command, " ")
+	}
+	if k.timeout <= 0 {
+		k.timeout = DefaultExecTimeout
+	}
+	n := c.Concurrency
+	if n <= 0 {
+		n = 1
+	}
+	for i := 0; i < n; i++ {
+		go k.worker()
+	}
+	return k, nil
+}
+
+func (k *execHook) worker() {
+	for e := range k.queue {
+		k.run(e)
+	}
+}
+
+// run 执行一次命令, 等待它退出或超时
+func (k *execHook) run(e Event) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		hlog.Errorf("exec %s: marshal event: %v", k.name, err)
+		return
+	}
+	prefix := fmt.Sprintf("exec %s [%s %s]", k.name, e.Name, e.Type)
+	out := &lineLogger{prefix: prefix}
+
+	c := exec.Command(k.argv[0], k.argv[1:]...)
+	c.Env = append(os.Environ(), execEnv(e)...)
+	c.Stdin = bytes.NewReader(append(payload, '\n'))
+	c.Stdout, c.Stderr = out, out
+	// 独立的进程组, 超时时连同命令 fork 出的子进程一起杀掉
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	start := time.Now()
+	if err := c.Start(); err != nil {
+		hlog.Errorf("%s: %v", prefix, err)
+		return
+	}
+	done := make(chan error, 1)
+	go func() { done <- c.Wait() }()
+	timer := time.NewTimer(k.timeout)
+	defer timer.Stop()
+	select {
+	case err = <-done:
+	case <-timer.C:
+		syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+		err = fmt.Errorf("timed out after %s, killed", k.timeout)
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			// 离开了进程组的子进程仍占着输出, 不再等待
+			hlog.Errorf("%s: %v, output is still open", prefix, err)
+			return
+		}
+	}
+	out.flush()
+
+	took := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		hlog.Errorf("%s: failed after %s: %v", prefix, took, err)
+		return
+	}
+	hlog.Infof("%s: done in %s", prefix, took)
+}
+
+// execEnv 返回传给命令的 SUPERS_EVENT_* 环境变量; 没有值的字段为空字符串
+func execEnv(e Event) []string {
+	coreDumped := "0"
+	if e.CoreDumped {
+		coreDumped = "1"
+	}
+	vars := []struct{ key, value string }{
+		{"ID", e.ID},
+		{"SEQ", strconv.FormatUint(e.Seq, 10)},
+		{"TIME", e.Time.Format(time.RFC3339Nano)},
+		{"HOST", e.Host},
+		{"TYPE", string(e.Type)},
+		{"SERVICE", e.Name},
+		{"PID", strconv.Itoa(e.PID)},
+		{"EXIT_CODE", strconv.Itoa(e.ExitCode)},
+		{"SIGNAL", e.Signal},
+		{"CORE_DUMPED", coreDumped},
+		{"UPTIME_SEC", strconv.FormatFloat(e.UptimeSec, 'f', -1, 64)},
+		{"RESTARTS", strconv.Itoa(e.Restarts)},
+		{"ERROR", e.Error},
+		{"STOP_RESULT", e.StopResult},
+		{"STATE", e.State},
+		{"PREV_STATE", e.PrevState},
+	}
+	env := make([]string, 0, len(vars))
+	for _, v := range vars {
+		env = append(env, "SUPERS_EVENT_"+v.key+"="+v.value)
+	}
+	return env
+}
+
+// lineLogger 把命令的输出逐行写入 superd 的日志, 最多 execOutputMax 字节
+type lineLogger struct {
+	prefix    string
+	buf       []byte
+	n         int
+	truncated bool
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	if l.n >= execOutputMax {
+		if !l.truncated {
+			l.truncated = true
+			hlog.Warnf("%s: output exceeds %d bytes, truncated", l.prefix, execOutputMax)
+		}
+		return len(p), nil
+	}
+	l.n += len(p)
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		hlog.Infof("%s: %s", l.prefix, bytes.TrimRight(l.buf[:i], "\r"))
+		l.buf = l.buf[i+1:]
+	}
+	if len(l.buf) >= execLineMax {
+		l.flush()
+	}
+	return len(p), nil
+}
+
+// flush 写出没有换行符的剩余输出
+func (l *lineLogger) flush() {
+	if len(l.buf) > 0 {
+		hlog.Infof("%s: %s", l.prefix, l.buf)
+		l.buf = nil
+	}
+}
@@ -0,0 +1,358 @@
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+
+	"github.com/litongjava/supers/utils"
+)
+
+// DefaultHistorySize 是默认保留的事件数, 可以用 config.yml 的 events.history_size 修改
+const DefaultHistorySize = 1000
+
+// subscriberBuffer 是每个订阅者的缓冲; 订阅者跟不上时丢弃事件, 可以从 Seq 的间隔发现
+const subscriberBuffer = 256
+
+// Filter 选择事件; 为零值的条件不限制
+type Filter struct {
+	Services []string  // 服务名, 支持 * 通配符
+	Types    []string  // 事件类型, 支持 * 通配符, 如 process.*
+	Since    time.Time // 不早于
+	Until    time.Time // 不晚于
+	After    uint64    // Seq 大于
+}
+
+// Match reports whether e satisfies the filter.
+func (f Filter) Match(e Event) bool {
+	if e.Seq <= f.After {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+	return matchAny(f.Types, string(e.Type)) && matchAny(f.Services, e.Name)
+}
+
+// Validate 检查 Services 和 Types 中的通配符
+func (f Filter) Validate() error {
+	return validPatterns(f.Services, f.Types)
+}
+
+// Restrict 把 Services 限制在 names 中: 保留被原条件匹配的名字, 原条件为空时使用全部 names.
+// 结果为空时返回 false, 表示这个过滤条件不会匹配任何允许的服务
+func (f Filter) Restrict(names []string) (Filter, bool) {
+	var list []string
+	for _, n := range names {
+		if matchAny(f.Services, n) {
+			list = append(list, n)
+		}
+	}
+	f.Services = list
+	return f, len(list) > 0
+}
+
+// History 在内存中保留最近的事件, 并把新事件推送给订阅者.
+// 配置了 history_file 时事件同时追加到文件中, superd 重启后可以继续查询, Seq 也接着递增.
+// History 应该用 RegisterSync 注册, 这样保存和推送的顺序与 Seq 一致.
+// Handle 在 emitMu 下被调用, 写文件交给后台 goroutine, 不会因为磁盘慢而阻塞 Emit.
+type History struct {
+	mu      sync.Mutex
+	size    int
+	events  []Event // 按 Seq 从旧到新
+	subs    map[*subscriber]struct{}
+	persist bool    // 配置了历史文件
+	pending []Event // 还没有写入文件的事件
+	kick    chan struct{}
+	writer  sync.Once
+
+	// fileMu 保护文件, 写文件时不持有 mu; 两者都要持有时先锁 fileMu
+	fileMu sync.Mutex
+	path   string
+	file   *os.File
+	lines  int // 文件中的行数, 超过 size 的两倍时重写
+}
+
+type subscriber struct {
+	filter  Filter
+	ch      chan Event
+	dropped uint64
+}
+
+// NewHistory creates a history using the config.
+func NewHistory() *History {
+	h := &History{size: DefaultHistorySize, subs: make(map[*subscriber]struct{}), kick: make(chan struct{}, 1)}
+	if err := h.Configure(utils.CurrentConfig().Events); err != nil {
+		hlog.Errorf("event history: %v", err)
+	}
+	return h
+}
+
+// Configure 修改保留的事件数和历史文件; 更换文件时先把未写入的事件写到原来的文件, 再读入新文件中的事件
+func (h *History) Configure(cfg *utils.EventsConfig) error {
+	size, file := DefaultHistorySize, ""
+	if cfg != nil {
+		if cfg.HistorySize > 0 {
+			size = cfg.HistorySize
+		}
+		file = cfg.HistoryFile
+	}
+
+	h.fileMu.Lock()
+	defer h.fileMu.Unlock()
+	h.mu.Lock()
+	h.size = size
+	if len(h.events) > size {
+		h.events = append([]Event(nil), h.events[len(h.events)-size:]...)
+	}
+	h.mu.Unlock()
+	if file == h.path {
+		if h.file != nil && h.lines > 2*size {
+			return h.rewriteFileLocked(h.snapshot())
+		}
+		return nil
+	}
+
+	h.flushLocked()
+	if h.file != nil {
+		h.file.Close()
+		h.file, h.path, h.lines = nil, "", 0
+	}
+	h.mu.Lock()
+	h.persist = false
+	h.mu.Unlock()
+	if file == "" {
+		return nil
+	}
+	loaded, err := readHistory(file)
+	if err != nil {
+		return err
+	}
+	h.path = file
+	h.mu.Lock()
+	h.events = mergeEvents(loaded, h.events, size)
+	if n := len(h.events); n > 0 {
+		resumeSeq(h.events[n-1].Seq)
+	}
+	h.persist = true
+	h.mu.Unlock()
+	h.writer.Do(func() { go h.writeLoop() })
+	return h.rewriteFileLocked(h.snapshot())
+}
+
+// readHistory 读取历史文件; 文件不存在时返回空, 无法解析的行被跳过
+func readHistory(file string) ([]Event, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var list []Event
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		var e Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil || e.Seq == 0 {
+			continue
+		}
+		list = append(list, e)
+	}
+	return list, sc.Err()
+}
+
+// mergeEvents 合并两组按 Seq 排好序的事件, 去掉重复的, 保留最新的 size 个
+func mergeEvents(a, b []Event, size int) []Event {
+	out := make([]Event, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		var e Event
+		switch {
+		case j >= len(b) || (i < len(a) && a[i].Seq < b[j].Seq):
+			e, i = a[i], i+1
+		case i >= len(a) || b[j].Seq < a[i].Seq:
+			e, j = b[j], j+1
+		default:
+			e, i, j = a[i], i+1, j+1
+		}
+		if n := len(out); n > 0 && out[n-1].Seq >= e.Seq {
+			continue
+		}
+		out = append(out, e)
+	}
+	if len(out) > size {
+		out = out[len(out)-size:]
+	}
+	return out
+}
+
+// snapshot 复制内存中的事件并清空 pending, 这些事件已经包含在返回值中
+func (h *History) snapshot() []Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.pending = nil
+	return append([]Event(nil), h.events...)
+}
+
+// rewriteFileLocked 用 events 重写历史文件(先写临时文件再 rename), 然后以追加方式打开; 调用方持有 fileMu
+func (h *History) rewriteFileLocked(events []Event) error {
+	if h.file != nil {
+		h.file.Close()
+		h.file = nil
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0o700); err != nil {
+		return err
+	}
+	tmp := h.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	f.Close()
+	if err := os.Rename(tmp, h.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	h.file, err = os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	h.lines = len(events)
+	return nil
+}
+
+// Handle 保存事件并推送给订阅者; 配置了历史文件时通知后台 goroutine 写入
+func (h *History) Handle(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events = append(h.events, e)
+	if len(h.events) > h.size {
+		// 复制一份, 避免底层数组无限增长
+		h.events = append(make([]Event, 0, h.size), h.events[len(h.events)-h.size:]...)
+	}
+	if h.persist {
+		h.pending = append(h.pending, e)
+		select {
+		case h.kick <- struct{}{}:
+		default:
+		}
+	}
+	for s := range h.subs {
+		if !s.filter.Match(e) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			if s.dropped == 0 {
+				hlog.Warnf("event history: a subscriber is too slow, dropping events")
+			}
+			s.dropped++
+		}
+	}
+}
+
+// writeLoop 把 Handle 收到的事件追加到历史文件
+func (h *History) writeLoop() {
+	for range h.kick {
+		h.Flush()
+	}
+}
+
+// Flush 把还没有写入的事件追加到历史文件; superd 退出前调用, 避免丢失最后的事件
+func (h *History) Flush() {
+	h.fileMu.Lock()
+	defer h.fileMu.Unlock()
+	h.flushLocked()
+}
+
+func (h *History) flushLocked() {
+	h.mu.Lock()
+	pending, size := h.pending, h.size
+	h.pending = nil
+	h.mu.Unlock()
+	if h.file == nil || len(pending) == 0 {
+		return
+	}
+	if err := h.appendFileLocked(pending, size); err != nil {
+		hlog.Errorf("event history %s: %v", h.path, err)
+	}
+}
+
+func (h *History) appendFileLocked(events []Event, size int) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	if _, err := h.file.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	h.lines += len(events)
+	if h.lines > 2*size {
+		return h.rewriteFileLocked(h.snapshot())
+	}
+	return nil
+}
+
+// Query 返回满足 f 的事件, 从旧到新; limit > 0 时只返回最新的 limit 个
+func (h *History) Query(f Filter, limit int) []Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.queryLocked(f, limit)
+}
+
+func (h *History) queryLocked(f Filter, limit int) []Event {
+	var list []Event
+	for _, e := range h.events {
+		if f.Match(e) {
+			list = append(list, e)
+		}
+	}
+	if limit > 0 && len(list) > limit {
+		list = list[len(list)-limit:]
+	}
+	return list
+}
+
+// Follow 返回 Query(f, limit) 的结果, 并把之后满足 f 的新事件发到 channel 中,
+// 两者之间不会遗漏或重复. 不再需要时调用 cancel.
+func (h *History) Follow(f Filter, limit int) (past []Event, ch <-chan Event, cancel func()) {
+	s := &subscriber{filter: f, ch: make(chan Event, subscriberBuffer)}
+	h.mu.Lock()
+	past = h.queryLocked(f, limit)
+	h.subs[s] = struct{}{}
+	h.mu.Unlock()
+	return past, s.ch, func() {
+		h.mu.Lock()
+		delete(h.subs, s)
+		h.mu.Unlock()
+	}
+}
@@ -0,0 +1,78 @@
+package events
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/litongjava/supers/utils"
+)
+
+func newTestHistory(t *testing.T, cfg *utils.EventsConfig) *History {
+	h := &History{size: DefaultHistorySize, subs: make(map[*subscriber]struct{}), kick: make(chan struct{}, 1)}
+	if err := h.Configure(cfg); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestHistoryFile(t *testing.T) {
+	cfg := &utils.EventsConfig{HistoryFile: filepath.Join(t.TempDir(), "events.jsonl"), HistorySize: 3}
+	h := newTestHistory(t, cfg)
+	for seq := uint64(1); seq <= 10; seq++ {
+		h.Handle(Event{Seq: seq, Name: "app", Type: EventProcessStarted})
+	}
+	h.Flush()
+
+	// 文件超过 size 的两倍时重写, 重新读入时只保留最新的 size 个
+	list, err := readHistory(cfg.HistoryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) > 2*cfg.HistorySize || list[len(list)-1].Seq != 10 {
+		t.Errorf("file has %d events, last %+v", len(list), list[len(list)-1])
+	}
+	got := newTestHistory(t, cfg).Query(Filter{}, 0)
+	if len(got) != 3 || got[0].Seq != 8 || got[2].Seq != 10 {
+		t.Errorf("reloaded events = %+v, want seq 8-10", got)
+	}
+}
+
+func TestHistoryHandleDoesNotWaitForDisk(t *testing.T) {
+	cfg := &utils.EventsConfig{HistoryFile: filepath.Join(t.TempDir(), "events.jsonl")}
+	h := newTestHistory(t, cfg)
+	past, ch, cancel := h.Follow(Filter{}, 0)
+	defer cancel()
+	if len(past) != 0 {
+		t.Fatalf("past = %+v", past)
+	}
+
+	// 模拟写文件很慢: 持有 fileMu 时 Handle 仍然立即返回, 订阅者也能收到事件
+	h.fileMu.Lock()
+	done := make(chan struct{})
+	go func() {
+		h.Handle(Event{Seq: 1, Name: "app", Type: EventProcessExited})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		h.fileMu.Unlock()
+		t.Fatal("Handle waited for the history file")
+	}
+	select {
+	case e := <-ch:
+		if e.Seq != 1 {
+			t.Errorf("followed %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Error("subscriber did not receive the event")
+	}
+	h.fileMu.Unlock()
+
+	// 写入在后台完成
+	waitFor(t, "the event to be written", func() bool {
+		list, err := readHistory(cfg.HistoryFile)
+		return err == nil && len(list) == 1 && list[0].Seq == 1
+	})
+}
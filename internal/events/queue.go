@@ -0,0 +1,167 @@
+package events
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// queued 是队列中等待投递的一个事件
+type queued struct {
+	seq  uint64
+	body []byte // Event 的 JSON
+	at   time.Time
+}
+
+// queue 是一个有界的 FIFO 队列. dir 不为空时每个事件同时写成 dir 下的一个文件,
+// superd 重启或接收方短暂不可用时事件不会丢失; 队列满时丢弃最旧的事件.
+type queue struct {
+	dir string
+	max int
+
+	mu      sync.Mutex
+	items   []queued
+	next    uint64
+	dropped uint64
+	notify  chan struct{}
+}
+
+// openQueue 创建队列并读入 dir 中上次未投递的事件
+func openQueue(dir string, max int) (*queue, error) {
+	q := &queue{dir: dir, max: max, next: 1, notify: make(chan struct{}, 1)}
+	if dir == "" {
+		return q, nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range files {
+		name := fi.Name()
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, ".json"), 10, 64)
+		if err != nil {
+			continue
+		}
+		body, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		q.items = append(q.items, queued{seq: seq, body: body, at: fi.ModTime()})
+		if seq >= q.next {
+			q.next = seq + 1
+		}
+	}
+	sort.Slice(q.items, func(i, j int) bool { return q.items[i].seq < q.items[j].seq })
+	for len(q.items) > q.max {
+		q.dropOldestLocked()
+	}
+	if len(q.items) > 0 {
+		q.notify <- struct{}{}
+	}
+	return q, nil
+}
+
+func (q *queue) file(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d.json", seq))
+}
+
+// push 追加一个事件, 返回因队列已满而被丢弃的事件数
+func (q *queue) push(body []byte) (int, error) {
+	return q.pushAt(body, time.Now())
+}
+
+// pushAt 追加一个在 at 时入队的事件; 文件的修改时间设为 at, 重新打开队列时按它计算 MaxAge
+func (q *queue) pushAt(body []byte, at time.Time) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item := queued{seq: q.next, body: body, at: at}
+	q.next++
+	if q.dir != "" {
+		// 先写临时文件再 rename, 读到的文件总是完整的
+		tmp := q.file(item.seq) + ".tmp"
+		if err := ioutil.WriteFile(tmp, body, 0o600); err != nil {
+			return 0, err
+		}
+		os.Chtimes(tmp, at, at)
+		if err := os.Rename(tmp, q.file(item.seq)); err != nil {
+			os.Remove(tmp)
+			return 0, err
+		}
+	}
+	q.items = append(q.items, item)
+	dropped := 0
+	for len(q.items) > q.max {
+		q.dropOldestLocked()
+		dropped++
+	}
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return dropped, nil
+}
+
+func (q *queue) dropOldestLocked() {
+	if q.dir != "" {
+		os.Remove(q.file(q.items[0].seq))
+	}
+	q.items = q.items[1:]
+	q.dropped++
+}
+
+// peek 返回最旧的事件
+func (q *queue) peek() (queued, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items) == 0 {
+		return queued{}, false
+	}
+	return q.items[0], true
+}
+
+// remove 删除已投递(或放弃)的事件; 该事件可能已因队列满被丢弃
+func (q *queue) remove(seq uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, it := range q.items {
+		if it.seq == seq {
+			q.items = append(q.items[:i:i], q.items[i+1:]...)
+			if q.dir != "" {
+				os.Remove(q.file(seq))
+			}
+			return
+		}
+	}
+}
+
+// moveTo 按顺序把所有事件移到 dst 的末尾, 已移走的事件从 q 中删除
+func (q *queue) moveTo(dst *queue) error {
+	q.mu.Lock()
+	items := append([]queued(nil), q.items...)
+	q.mu.Unlock()
+	for _, it := range items {
+		if _, err := dst.pushAt(it.body, it.at); err != nil {
+			return err
+		}
+		q.remove(it.seq)
+	}
+	return nil
+}
+
+func (q *queue) stats() (pending int, dropped uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items), q.dropped
+}
@@ -2,33 +2,568 @@ package events
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
 
+	"github.com/litongjava/supers/internal/protocol"
 	"github.com/litongjava/supers/utils"
 )
 
+// webhook 投递的默认值, 可以在 config.yml 的 events 段中修改
+const (
+	DefaultWebhookTimeout   = 10 * time.Second
+	DefaultWebhookMaxAge    = time.Hour
+	DefaultWebhookQueueSize = 1000
+	DefaultWebhookQueueDir  = "/var/lib/super/webhooks"
+
+	minRetryDelay = time.Second
+	maxRetryDelay = time.Minute
+)
+
+// 配置了 secret 的 webhook 在请求中带上签名:
+//
+//	X-Supers-Timestamp: 发送时的 Unix 时间(秒)
+//	X-Supers-Signature: sha256=<hex(HMAC-SHA256(secret, timestamp + "." + body))>
+//
+// 接收方用同样的 secret 计算并比较签名, 并拒绝时间相差太多的请求以防重放.
+// 每次重试都会重新签名.
+const (
+	HeaderEvent     = "X-Supers-Event"
+	HeaderTimestamp = "X-Supers-Timestamp"
+	HeaderSignature = "X-Supers-Signature"
+)
+
 // WebhookHandler sends events to configured webhook URLs.
+// 每个 URL 有自己的过滤条件、队列和投递 goroutine: 请求带超时, 失败后指数退避重试,
+// 直到成功或事件超过 MaxAge; 一个接收方不可用不会影响其他接收方.
 type WebhookHandler struct {
-	URLs []string
+	mu    sync.Mutex
+	sinks map[string]*webhookSink
+	order []string
 }
 
 // NewWebhookHandler creates a handler using the config.
 func NewWebhookHandler() *WebhookHandler {
-	if utils.CONFIG.Events == nil {
-		return &WebhookHandler{}
+	w := &WebhookHandler{sinks: make(map[string]*webhookSink)}
+	if err := w.Configure(utils.CurrentConfig().Events); err != nil {
+		hlog.Errorf("webhook: %v", err)
 	}
-	return &WebhookHandler{URLs: utils.CONFIG.Events.Webhooks}
+	return w
 }
 
-// Handle marshals the event and posts to each URL.
+// Configure 按配置增删 webhook; 已存在的 URL 保留队列, 只更新投递设置、过滤条件和模板,
+// queue_dir 改变时队列移到新目录. 被删除的 URL 停止投递, 未投递的事件随队列一起删除.
+func (w *WebhookHandler) Configure(cfg *utils.EventsConfig) error {
+	opts := webhookOptions{
+		timeout:   DefaultWebhookTimeout,
+		maxAge:    DefaultWebhookMaxAge,
+		queueSize: DefaultWebhookQueueSize,
+		queueDir:  DefaultWebhookQueueDir,
+	}
+	var hooks []utils.WebhookConfig
+	if cfg != nil {
+		hooks = cfg.Webhooks
+		if cfg.Timeout > 0 {
+			opts.timeout = cfg.Timeout
+		}
+		if cfg.MaxAge > 0 {
+			opts.maxAge = cfg.MaxAge
+		}
+		if cfg.QueueSize > 0 {
+			opts.queueSize = cfg.QueueSize
+		}
+		if cfg.QueueDir != "" {
+			opts.queueDir = cfg.QueueDir
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var errs []string
+	keep := make(map[string]bool, len(hooks))
+	var order []string
+	for _, h := range hooks {
+		u := h.URL
+		if keep[u] {
+			// 队列和投递状态按 URL 区分, 同一个 URL 只能配置一次
+			errs = append(errs, fmt.Sprintf("duplicate webhook %s ignored", redactURL(u)))
+			continue
+		}
+		if _, err := url.ParseRequestURI(u); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid webhook %s: %v", redactURL(u), err))
+			continue
+		}
+		old := w.sinks[u]
+		ep, err := parseEndpoint(h)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("webhook %s: %v", redactURL(u), err))
+			if old == nil {
+				continue
+			}
+			// 已存在的 webhook 继续使用之前的过滤条件和模板, 队列不丢
+			ep = old.endpoint()
+		}
+		s, err := w.sinkFor(u, old, opts, ep)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("webhook %s: %v", redactURL(u), err))
+		}
+		if s != nil {
+			keep[u] = true
+			order = append(order, u)
+		}
+	}
+	for u, s := range w.sinks {
+		if !keep[u] {
+			s.close()
+			delete(w.sinks, u)
+		}
+	}
+	w.order = order
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// sinkFor 返回 u 的 sink: 新的 URL 创建 sink 并开始投递; 已存在的 sink 更新设置.
+// queue_dir 改变时在新目录重建 sink, 把未投递的事件移过去; 重建失败时继续使用原来的队列.
+func (w *WebhookHandler) sinkFor(u string, old *webhookSink, opts webhookOptions, ep *webhookEndpoint) (*webhookSink, error) {
+	if old != nil && old.queue.dir == queuePath(u, opts.queueDir) {
+		old.setOptions(opts)
+		old.setEndpoint(ep)
+		return old, nil
+	}
+	s, err := newWebhookSink(u, opts, ep)
+	if err != nil {
+		if old == nil {
+			return nil, err
+		}
+		opts.queueDir = old.opts.queueDir
+		old.setOptions(opts)
+		old.setEndpoint(ep)
+		return old, fmt.Errorf("keeping queue in %s: %v", opts.queueDir, err)
+	}
+	if old != nil {
+		old.moveTo(s)
+	}
+	w.sinks[u] = s
+	go s.run()
+	return s, nil
+}
+
+// Handle 把事件放入过滤条件匹配的 webhook 的队列, 由各自的 goroutine 投递
 func (w *WebhookHandler) Handle(e Event) {
-	payload, _ := json.Marshal(e)
-	for _, url := range w.URLs {
-		_, err := http.Post(url, "application/json", bytes.NewBuffer(payload))
+	payload, err := json.Marshal(e)
+	if err != nil {
+		hlog.Errorf("webhook: marshal event: %v", err)
+		return
+	}
+	w.mu.Lock()
+	sinks := make([]*webhookSink, 0, len(w.order))
+	for _, u := range w.order {
+		sinks = append(sinks, w.sinks[u])
+	}
+	w.mu.Unlock()
+	for _, s := range sinks {
+		if s.endpoint().match(e) {
+			s.enqueue(payload)
+		}
+	}
+}
+
+// Status 返回各 webhook 的投递状态, 顺序与配置相同
+func (w *WebhookHandler) Status() []protocol.WebhookStatus {
+	w.mu.Lock()
+	sinks := make([]*webhookSink, 0, len(w.order))
+	for _, u := range w.order {
+		sinks = append(sinks, w.sinks[u])
+	}
+	w.mu.Unlock()
+	list := make([]protocol.WebhookStatus, 0, len(sinks))
+	for _, s := range sinks {
+		list = append(list, s.status())
+	}
+	return list
+}
+
+type webhookOptions struct {
+	timeout   time.Duration
+	maxAge    time.Duration
+	queueSize int
+	queueDir  string
+}
+
+// webhookEndpoint 是解析后的 utils.WebhookConfig 中与投递内容有关的部分
+type webhookEndpoint struct {
+	events      []string
+	services    []string
+	body        *template.Template // nil 时发送 Event 的 JSON
+	headers     map[string]*template.Template
+	contentType string
+	secret      []byte
+}
+
+// templateFuncs 是模板中可用的函数; json 用于在 JSON 请求体中安全地嵌入字符串
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func parseEndpoint(c utils.WebhookConfig) (*webhookEndpoint, error) {
+	ep := &webhookEndpoint{
+		events:      c.Events,
+		services:    c.Services,
+		headers:     make(map[string]*template.Template, len(c.Headers)),
+		contentType: c.ContentType,
+	}
+	if err := validPatterns(c.Events, c.Services); err != nil {
+		return nil, err
+	}
+	if c.Template != "" {
+		t, err := template.New("body").Funcs(templateFuncs).Option("missingkey=error").Parse(c.Template)
 		if err != nil {
-			hlog.Error(err)
+			return nil, err
 		}
+		ep.body = t
+	}
+	for k, v := range c.Headers {
+		t, err := template.New(k).Funcs(templateFuncs).Option("missingkey=error").Parse(v)
+		if err != nil {
+			return nil, err
+		}
+		ep.headers[http.CanonicalHeaderKey(k)] = t
+	}
+	if ep.contentType == "" {
+		ep.contentType = "application/json"
+	}
+	if c.Secret != "" {
+		ep.secret = []byte(c.Secret)
+	}
+	return ep, nil
+}
+
+// match 检查事件是否满足 events 和 services 过滤条件
+func (ep *webhookEndpoint) match(e Event) bool {
+	return matchAny(ep.events, string(e.Type)) && matchAny(ep.services, e.Name)
+}
+
+// matchAny 按通配符匹配, 列表为空时匹配全部
+func matchAny(patterns []string, s string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validPatterns 检查 matchAny 使用的通配符
+func validPatterns(lists ...[]string) error {
+	for _, list := range lists {
+		for _, p := range list {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q", p)
+			}
+		}
+	}
+	return nil
+}
+
+// request 按模板生成请求; body 是队列中 Event 的 JSON
+func (ep *webhookEndpoint) request(u string, body []byte) (*http.Request, error) {
+	var e Event
+	if err := json.Unmarshal(body, &e); err != nil {
+		return nil, fmt.Errorf("decode event: %v", err)
+	}
+	if ep.body != nil {
+		var buf bytes.Buffer
+		if err := ep.body.Execute(&buf, e); err != nil {
+			return nil, fmt.Errorf("template: %v", err)
+		}
+		body = buf.Bytes()
+	}
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", ep.contentType)
+	req.Header.Set(HeaderEvent, string(e.Type))
+	for k, t := range ep.headers {
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, e); err != nil {
+			return nil, fmt.Errorf("header %s: %v", k, err)
+		}
+		req.Header.Set(k, buf.String())
+	}
+	if ep.secret != nil {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(HeaderTimestamp, ts)
+		req.Header.Set(HeaderSignature, Sign(ep.secret, ts, body))
+	}
+	return req, nil
+}
+
+// Sign 计算 X-Supers-Signature 的值, 接收方可以用它校验请求
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookSink 负责一个 URL 的投递
+type webhookSink struct {
+	url   string
+	queue *queue
+	stop  chan struct{}
+
+	mu          sync.Mutex
+	opts        webhookOptions
+	ep          *webhookEndpoint
+	client      *http.Client
+	delivered   uint64
+	failed      uint64
+	attempts    int // 当前队首事件已尝试的次数
+	lastSuccess time.Time
+	lastAttempt time.Time
+	lastError   string
+	nextRetry   time.Time
+}
+
+// queuePath 返回 u 在 dir 下的队列目录: 每个 URL 一个子目录, 用 URL 的摘要命名,
+// 避免把 URL 中的 token 写进路径
+func queuePath(u, dir string) string {
+	sum := sha256.Sum256([]byte(u))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8]))
+}
+
+func newWebhookSink(u string, opts webhookOptions, ep *webhookEndpoint) (*webhookSink, error) {
+	q, err := openQueue(queuePath(u, opts.queueDir), opts.queueSize)
+	if err != nil {
+		return nil, err
+	}
+	s := &webhookSink{url: u, queue: q, stop: make(chan struct{}), ep: ep}
+	s.setOptions(opts)
+	return s, nil
+}
+
+func (s *webhookSink) setOptions(opts webhookOptions) {
+	s.mu.Lock()
+	s.opts = opts
+	s.client = &http.Client{Timeout: opts.timeout}
+	s.mu.Unlock()
+	s.queue.mu.Lock()
+	s.queue.max = opts.queueSize
+	s.queue.mu.Unlock()
+}
+
+func (s *webhookSink) setEndpoint(ep *webhookEndpoint) {
+	s.mu.Lock()
+	s.ep = ep
+	s.mu.Unlock()
+}
+
+func (s *webhookSink) endpoint() *webhookEndpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ep
+}
+
+func (s *webhookSink) close() {
+	close(s.stop)
+	s.removeQueue()
+}
+
+func (s *webhookSink) removeQueue() {
+	if s.queue.dir != "" {
+		if err := os.RemoveAll(s.queue.dir); err != nil {
+			hlog.Warnf("webhook %s: remove queue: %v", redactURL(s.url), err)
+		}
+	}
+}
+
+// moveTo 停止投递, 把未投递的事件和投递统计交给 dst, 然后删除原来的队列目录.
+// 正在投递的事件也会移过去, 接收方可能收到两次.
+func (s *webhookSink) moveTo(dst *webhookSink) {
+	close(s.stop)
+	if err := s.queue.moveTo(dst.queue); err != nil {
+		hlog.Errorf("webhook %s: move queue to %s: %v; undelivered events are left in %s", redactURL(s.url), dst.queue.dir, err, s.queue.dir)
+	} else {
+		s.removeQueue()
+	}
+	s.mu.Lock()
+	delivered, failed, lastSuccess, lastAttempt, lastError := s.delivered, s.failed, s.lastSuccess, s.lastAttempt, s.lastError
+	s.mu.Unlock()
+	dst.mu.Lock()
+	dst.delivered, dst.failed, dst.lastSuccess, dst.lastAttempt, dst.lastError = delivered, failed, lastSuccess, lastAttempt, lastError
+	dst.mu.Unlock()
+}
+
+func (s *webhookSink) enqueue(payload []byte) {
+	dropped, err := s.queue.push(payload)
+	if err != nil {
+		hlog.Errorf("webhook %s: queue event: %v", redactURL(s.url), err)
+		return
+	}
+	if dropped > 0 {
+		hlog.Warnf("webhook %s: queue is full, dropped %d oldest event(s)", redactURL(s.url), dropped)
+	}
+}
+
+// run 按顺序投递队列中的事件; 队首事件失败时退避重试, 期间后面的事件等待
+func (s *webhookSink) run() {
+	for {
+		item, ok := s.queue.peek()
+		if !ok {
+			select {
+			case <-s.queue.notify:
+				continue
+			case <-s.stop:
+				return
+			}
+		}
+
+		s.mu.Lock()
+		client, maxAge, ep := s.client, s.opts.maxAge, s.ep
+		s.attempts++
+		attempts := s.attempts
+		s.lastAttempt = time.Now()
+		s.mu.Unlock()
+
+		retry, err := deliver(client, ep, s.url, item.body)
+		now := time.Now()
+		s.mu.Lock()
+		if err == nil {
+			s.delivered++
+			s.attempts = 0
+			s.lastSuccess, s.lastError, s.nextRetry = now, "", time.Time{}
+			s.mu.Unlock()
+			s.queue.remove(item.seq)
+			continue
+		}
+		s.lastError = err.Error()
+		if !retry || now.Sub(item.at) >= maxAge {
+			s.failed++
+			s.attempts = 0
+			s.nextRetry = time.Time{}
+			s.mu.Unlock()
+			hlog.Errorf("webhook %s: giving up on event after %d attempt(s): %v", redactURL(s.url), attempts, err)
+			s.queue.remove(item.seq)
+			continue
+		}
+		delay := retryDelay(attempts)
+		s.nextRetry = now.Add(delay)
+		s.mu.Unlock()
+		hlog.Warnf("webhook %s: attempt %d failed, retrying in %s: %v", redactURL(s.url), attempts, delay, err)
+
+		select {
+		case <-time.After(delay):
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *webhookSink) status() protocol.WebhookStatus {
+	pending, dropped := s.queue.stats()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := protocol.WebhookStatus{
+		URL:       redactURL(s.url),
+		Pending:   pending,
+		Delivered: s.delivered,
+		Failed:    s.failed,
+		Dropped:   dropped,
+		Attempts:  s.attempts,
+		LastError: s.lastError,
+	}
+	for _, t := range []struct {
+		src time.Time
+		dst **time.Time
+	}{{s.lastSuccess, &st.LastSuccess}, {s.lastAttempt, &st.LastAttempt}, {s.nextRetry, &st.NextRetry}} {
+		if !t.src.IsZero() {
+			v := t.src
+			*t.dst = &v
+		}
+	}
+	return st
+}
+
+// deliver 发送一次请求; retry 表示失败是否值得重试(网络错误、5xx、408、429).
+// 模板执行失败不会因重试而改变, 直接放弃.
+func deliver(client *http.Client, ep *webhookEndpoint, u string, body []byte) (retry bool, err error) {
+	req, err := ep.request(u, body)
+	if err != nil {
+		return false, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// *url.Error 的信息带完整 URL, 去掉以免 token 出现在日志和状态中
+		if ue, ok := err.(*url.Error); ok {
+			err = ue.Err
+		}
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("HTTP %s", resp.Status)
+	switch {
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests:
+		return true, err
+	}
+	return false, err
+}
+
+// retryDelay 是第 n 次失败后的等待时间: 1s, 2s, 4s ... 最多 1 分钟
+func retryDelay(n int) time.Duration {
+	d := minRetryDelay
+	for i := 1; i < n && d < maxRetryDelay; i++ {
+		d *= 2
+	}
+	if d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	return d
+}
+
+// redactURL 隐去 URL 中的密码和 query(机器人 webhook 的 token 通常在 query 中)
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "<invalid url>"
+	}
+	if u.User != nil {
+		u.User = url.User(u.User.Username())
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = "..."
 	}
+	return u.String()
 }
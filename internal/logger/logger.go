@@ -1,31 +1,237 @@
 package logger
 
 import (
-	"gopkg.in/natefinch/lumberjack.v2"
+	"fmt"
 	"io"
-	"os"
+	"log/syslog"
 	"path/filepath"
+	"strings"
+	"sync"
 )
 
-// SetupLog prepares rotating log writers for a given service name.
-func SetupLog(name string) (stdout io.Writer, stderr io.Writer, err error) {
-	baseDir := "/etc/super/logs"
-	dir := filepath.Join(baseDir, name)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+// Output kinds, 对应 StandardOutput= / StandardError= 的取值
+const (
+	OutputFile     = "file"     // 默认: <Dir>/<name>/stdout.log 或 stderr.log
+	OutputCombined = "combined" // <Dir>/<name>/combined.log, stdout 和 stderr 写同一个文件
+	OutputInherit  = "inherit"  // 仅用于 StandardError=: 与 stdout 写到同一个目标
+	OutputNull     = "null"     // 丢弃
+	OutputAppend   = "append"   // append:/path 追加写入指定文件
+	OutputTruncate = "truncate" // truncate:/path 启动时清空指定文件
+	OutputSyslog   = "syslog"   // syslog / journal, 以服务名作为 tag
+)
+
+// Output 是解析后的输出目标
+type Output struct {
+	Kind string
+	Path string // append: / truncate: / file: 指定的路径
+}
+
+// Options 描述一个服务的日志输出方式
+type Options struct {
+	Stdout Output
+	Stderr Output
+
+	Dir      string   // 日志根目录, 为空时使用全局默认值
+	Rotation Rotation // 为零值时使用全局默认值
+	Format   string   // FormatRaw / FormatText / FormatJSON, 为空时使用全局默认值
+}
+
+// ParseOutput 解析 StandardOutput= / StandardError= 的值
+func ParseOutput(s string) (Output, error) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, ':'); i > 0 {
+		kind, path := s[:i], s[i+1:]
+		if !filepath.IsAbs(path) {
+			return Output{}, fmt.Errorf("%s: path must be absolute: %q", kind, path)
+		}
+		switch kind {
+		case "file", "append":
+			return Output{Kind: OutputAppend, Path: path}, nil
+		case "truncate":
+			return Output{Kind: OutputTruncate, Path: path}, nil
+		}
+		return Output{}, fmt.Errorf("unknown output %q", s)
+	}
+	switch s {
+	case "", OutputFile:
+		return Output{Kind: OutputFile}, nil
+	case OutputCombined, OutputInherit, OutputNull:
+		return Output{Kind: s}, nil
+	case OutputSyslog, "journal", "journal+console", "syslog+console", "kmsg":
+		return Output{Kind: OutputSyslog}, nil
+	}
+	return Output{}, fmt.Errorf("unknown output %q", s)
+}
+
+// Path returns the log file of a service stream ("stdout" or "stderr"), or "" when it is not written to a file.
+func (o Options) Path(name, stream string) string {
+	out := o.Stdout
+	if stream == "stderr" && o.Stderr.Kind != OutputInherit {
+		out = o.Stderr
+	}
+	dir := o.Dir
+	if dir == "" {
+		dir = GetDefaults().Dir
+	}
+	switch out.Kind {
+	case "", OutputFile:
+		return filepath.Join(dir, name, stream+".log")
+	case OutputCombined:
+		return filepath.Join(dir, name, "combined.log")
+	case OutputAppend, OutputTruncate:
+		return out.Path
+	}
+	return ""
+}
+
+func (o Options) rotation() Rotation {
+	if o.Rotation == (Rotation{}) {
+		return GetDefaults().Rotation
+	}
+	return o.Rotation
+}
+
+func open(name, stream string, opts Options, starting bool) (io.Writer, error) {
+	out := opts.Stdout
+	if stream == "stderr" {
+		out = opts.Stderr
+	}
+	switch out.Kind {
+	case OutputNull:
+		return nil, nil
+	case OutputSyslog:
+		priority := syslog.LOG_INFO
+		if stream == "stderr" {
+			priority = syslog.LOG_ERR
+		}
+		w, err := syslog.New(priority|syslog.LOG_DAEMON, name)
+		if err != nil {
+			// syslog 不可用时退回默认文件, 不丢日志
+			fallback := opts
+			fallback.Stdout, fallback.Stderr = Output{Kind: OutputFile}, Output{Kind: OutputFile}
+			l, ferr := rotating(fallback.Path(name, stream), opts.rotation(), false)
+			if ferr != nil {
+				return nil, err
+			}
+			return l, fmt.Errorf("syslog unavailable, writing %s to %s: %v", stream, l.Filename, err)
+		}
+		return w, nil
+	}
+	l, err := rotating(opts.Path(name, stream), opts.rotation(), starting && out.Kind == OutputTruncate)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+var (
+	streamsMu sync.Mutex
+	streams   = make(map[string]*stream) // key: <name>/<stream>
+)
+
+func streamFor(name, kind string) *stream {
+	streamsMu.Lock()
+	defer streamsMu.Unlock()
+	key := name + "/" + kind
+	s, ok := streams[key]
+	if !ok {
+		s = &stream{kind: kind, tail: tailFor(name)}
+		streams[key] = s
+	}
+	return s
+}
+
+func inUse(l *rotatingFile) bool {
+	streamsMu.Lock()
+	defer streamsMu.Unlock()
+	for _, s := range streams {
+		s.mu.Lock()
+		w := s.w
+		s.mu.Unlock()
+		if w == io.Writer(l) {
+			return true
+		}
+	}
+	return false
+}
+
+func closeWriter(w io.Writer) {
+	switch w := w.(type) {
+	case *rotatingFile:
+		release(w)
+	case *syslog.Writer:
+		w.Close()
+	}
+}
+
+func setup(name string, opts Options, starting bool) (io.Writer, io.Writer, error) {
+	stdoutW, err := open(name, "stdout", opts, starting)
+	if stdoutW == nil && err != nil {
 		return nil, nil, err
 	}
-	// 用一个 lumberjack.Logger 既写 stdout 又写 stderr
-	combined := &lumberjack.Logger{
-		Filename:   filepath.Join(dir, "combined.log"),
-		MaxSize:    10, // megabytes
-		MaxBackups: 5,
-		MaxAge:     7, // days
-		Compress:   true,
-	}
-	// 如果还想同时在控制台看到 stderr，可以这样：
-	// stderr = io.MultiWriter(os.Stderr, combined)
-	// stdout = combined
-	stdout = combined
-	stderr = combined
-	return
+	stderrW := stdoutW
+	if opts.Stderr.Kind != OutputInherit {
+		var errStderr error
+		stderrW, errStderr = open(name, "stderr", opts, starting)
+		if errStderr != nil {
+			err = errStderr
+		}
+	}
+
+	format := opts.Format
+	if format == "" {
+		format = GetDefaults().Format
+	}
+	stdout, stderr := streamFor(name, "stdout"), streamFor(name, "stderr")
+	if starting {
+		stdout.setPID(0)
+		stderr.setPID(0)
+	}
+	oldOut, oldErr := stdout.swap(stdoutW, format), stderr.swap(stderrW, format)
+	for i, w := range []io.Writer{oldOut, oldErr} {
+		if w == nil || w == stdoutW || w == stderrW || (i == 1 && w == oldOut) {
+			continue
+		}
+		closeWriter(w)
+	}
+	return stdout, stderr, err
+}
+
+// SetupLog prepares writers for a service's stdout and stderr according to opts.
+// The writers stay valid across Update, so a running process keeps logging
+// after its settings change. A non-nil error with non-nil writers is a warning
+// (e.g. syslog fell back to a file).
+func SetupLog(name string, opts Options) (stdout io.Writer, stderr io.Writer, err error) {
+	return setup(name, opts, true)
+}
+
+// Update applies new settings to the writers returned by SetupLog.
+// truncate: outputs are not truncated again.
+func Update(name string, opts Options) error {
+	_, _, err := setup(name, opts, false)
+	return err
+}
+
+// SetPID records the PID shown in framed log lines of a service.
+func SetPID(name string, pid int) {
+	streamFor(name, "stdout").setPID(pid)
+	streamFor(name, "stderr").setPID(pid)
+}
+
+// Close releases the writers of a service that is no longer managed.
+func Close(name string) {
+	for _, kind := range []string{"stdout", "stderr"} {
+		streamsMu.Lock()
+		s, ok := streams[name+"/"+kind]
+		delete(streams, name+"/"+kind)
+		streamsMu.Unlock()
+		if ok {
+			if w := s.swap(nil, FormatRaw); w != nil {
+				closeWriter(w)
+			}
+		}
+	}
+	tailsMu.Lock()
+	delete(tails, name)
+	tailsMu.Unlock()
 }
@@ -0,0 +1,430 @@
+package logger
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// lumberjack 备份文件名中的时间格式: stdout-2006-01-02T15-04-05.000.log[.gz]
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// Query 描述 supers logs 的过滤条件
+type Query struct {
+	Stream string    // "stdout" / "stderr", 为空时两者都返回
+	Tail   int       // 只返回最后 N 行, 0 表示全部
+	Since  time.Time // 零值表示不限
+	Until  time.Time
+}
+
+// Line 是读取到的一行日志. 没有时间戳的行(raw 格式)沿用前一行的时间.
+type Line struct {
+	Time   time.Time
+	Stream string
+	Text   string
+}
+
+func (q Query) match(l Line) bool {
+	if q.Stream != "" && l.Stream != "" && l.Stream != q.Stream {
+		return false
+	}
+	if l.Time.IsZero() {
+		return true
+	}
+	if !q.Since.IsZero() && l.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && l.Time.After(q.Until) {
+		return false
+	}
+	return true
+}
+
+// ParseTime 解析 --since/--until: RFC3339、"2006-01-02 15:04:05"、"2006-01-02" 或相对时长 "10m"(即 now-10m)
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+// parseLine 从 text / json 格式的行中取出时间和流名
+func parseLine(text, stream string) Line {
+	l := Line{Stream: stream, Text: text}
+	if strings.HasPrefix(text, "{") {
+		var j jsonLine
+		if json.Unmarshal([]byte(text), &j) == nil {
+			if t, err := time.Parse(time.RFC3339, j.Time); err == nil {
+				l.Time = t
+			}
+			if j.Stream != "" {
+				l.Stream = j.Stream
+			}
+		}
+		return l
+	}
+	fields := strings.SplitN(text, " ", 3)
+	if len(fields) < 3 {
+		return l
+	}
+	t, err := time.Parse(time.RFC3339, fields[0])
+	if err != nil {
+		return l
+	}
+	l.Time = t
+	if s := strings.TrimSuffix(fields[1], ":"); s != fields[1] {
+		if i := strings.IndexByte(s, '['); i >= 0 {
+			s = s[:i]
+		}
+		l.Stream = s
+	}
+	return l
+}
+
+// files 返回 path 及其 lumberjack 备份, 按时间从旧到新排列.
+// since 不为零时跳过在 since 之前就已切割走的备份.
+func files(path string, since time.Time) []string {
+	dir := filepath.Dir(path)
+	ext := filepath.Ext(path)
+	prefix := strings.TrimSuffix(filepath.Base(path), ext) + "-"
+	matches, _ := filepath.Glob(filepath.Join(dir, prefix+"*"+ext+"*"))
+
+	type backup struct {
+		t    time.Time
+		path string
+	}
+	byName := make(map[string]backup)
+	for _, m := range matches {
+		base := filepath.Base(m)
+		plain := strings.TrimSuffix(base, ".gz")
+		t, err := time.Parse(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(plain, prefix), ext))
+		if err != nil {
+			continue
+		}
+		if !since.IsZero() && t.Before(since) {
+			continue
+		}
+		// 压缩过程中 .log 和 .log.gz 会同时存在, 以未压缩的为准
+		if old, ok := byName[plain]; ok && !strings.HasSuffix(old.path, ".gz") {
+			continue
+		}
+		byName[plain] = backup{t, m}
+	}
+	list := make([]backup, 0, len(byName))
+	for _, b := range byName {
+		list = append(list, b)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].t.Before(list[j].t) })
+
+	paths := make([]string, 0, len(list)+1)
+	for _, b := range list {
+		paths = append(paths, b.path)
+	}
+	return append(paths, path)
+}
+
+// source 依次读取一个日志文件及其备份
+type source struct {
+	stream string
+	paths  []string
+	last   time.Time
+
+	f       *os.File
+	scanner *bufio.Scanner
+}
+
+func (s *source) next() (Line, bool, error) {
+	for {
+		if s.scanner == nil {
+			if len(s.paths) == 0 {
+				return Line{}, false, nil
+			}
+			if err := s.open(s.paths[0]); err != nil {
+				return Line{}, false, err
+			}
+			s.paths = s.paths[1:]
+		}
+		if s.scanner.Scan() {
+			l := parseLine(s.scanner.Text(), s.stream)
+			if l.Time.IsZero() {
+				l.Time = s.last
+			}
+			s.last = l.Time
+			return l, true, nil
+		}
+		err := s.scanner.Err()
+		s.close()
+		if err != nil {
+			return Line{}, false, err
+		}
+	}
+}
+
+func (s *source) open(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		s.scanner = bufio.NewScanner(strings.NewReader(""))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		r = gz
+	}
+	s.f = f
+	s.scanner = bufio.NewScanner(r)
+	s.scanner.Buffer(make([]byte, 0, 64*1024), 4*maxLine)
+	return nil
+}
+
+func (s *source) close() {
+	if s.f != nil {
+		s.f.Close()
+		s.f = nil
+	}
+	s.scanner = nil
+}
+
+// sources 按 Query 选出需要读取的文件; stdout 和 stderr 写同一个文件时只读一次
+func sources(name string, opts Options, q Query) ([]*source, error) {
+	var list []*source
+	seen := make(map[string]bool)
+	for _, stream := range []string{"stdout", "stderr"} {
+		if q.Stream != "" && q.Stream != stream {
+			continue
+		}
+		path := opts.Path(name, stream)
+		if path == "" || seen[path] {
+			continue
+		}
+		seen[path] = true
+		s := &source{stream: stream, paths: files(path, q.Since)}
+		if opts.Path(name, "stdout") == opts.Path(name, "stderr") {
+			s.stream = "" // 同一文件, 流名只能从行内容中得知
+		}
+		list = append(list, s)
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("logs of %s are not written to a file", name)
+	}
+	return list, nil
+}
+
+// Read 读取服务日志, 按时间顺序对每一行调用 fn; stdout 和 stderr 分开存放时按时间合并
+func Read(name string, opts Options, q Query, fn func(Line) error) error {
+	srcs, err := sources(name, opts, q)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, s := range srcs {
+			s.close()
+		}
+	}()
+
+	var ring []Line
+	emit := func(l Line) error {
+		if !q.match(l) {
+			return nil
+		}
+		if q.Tail <= 0 {
+			return fn(l)
+		}
+		if len(ring) == q.Tail {
+			ring = ring[1:]
+		}
+		ring = append(ring, l)
+		return nil
+	}
+
+	heads := make([]*Line, len(srcs))
+	for {
+		// 取时间最早的一行
+		pick := -1
+		for i, s := range srcs {
+			if heads[i] == nil {
+				l, ok, err := s.next()
+				if err != nil {
+					return err
+				}
+				if !ok {
+					continue
+				}
+				heads[i] = &l
+			}
+			if pick < 0 || heads[i].Time.Before(heads[pick].Time) {
+				pick = i
+			}
+		}
+		if pick < 0 {
+			break
+		}
+		l := *heads[pick]
+		heads[pick] = nil
+		if err := emit(l); err != nil {
+			return err
+		}
+	}
+	for _, l := range ring {
+		if err := fn(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Follow 从当前文件末尾开始持续读取新写入的行, 直到 done 被关闭或 fn 返回错误.
+// 文件被 lumberjack 切割后先读完旧文件剩余的内容, 再切换到新文件.
+func Follow(name string, opts Options, q Query, done <-chan struct{}, fn func(Line) error) error {
+	srcs, err := sources(name, opts, q)
+	if err != nil {
+		return err
+	}
+	tails := make([]*tailer, 0, len(srcs))
+	for _, s := range srcs {
+		t := &tailer{path: s.paths[len(s.paths)-1], stream: s.stream}
+		t.reopen(true)
+		tails = append(tails, t)
+		defer t.close()
+	}
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		for _, t := range tails {
+			if err := t.poll(func(l Line) error {
+				if !q.match(l) {
+					return nil
+				}
+				return fn(l)
+			}); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-done:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// tailer 跟踪单个日志文件, 类似 tail -F
+type tailer struct {
+	path    string
+	stream  string
+	f       *os.File
+	r       *bufio.Reader
+	partial string
+	last    time.Time
+}
+
+func (t *tailer) reopen(seekEnd bool) {
+	t.close()
+	f, err := os.Open(t.path)
+	if err != nil {
+		return
+	}
+	if seekEnd {
+		f.Seek(0, io.SeekEnd)
+	}
+	t.f = f
+	t.r = bufio.NewReader(f)
+}
+
+func (t *tailer) close() {
+	if t.f != nil {
+		t.f.Close()
+		t.f = nil
+	}
+}
+
+func (t *tailer) drain(fn func(Line) error) error {
+	if t.f == nil {
+		return nil
+	}
+	for {
+		s, err := t.r.ReadString('\n')
+		if err != nil {
+			// 没有换行符的内容等下次再读
+			t.partial += s
+			return nil
+		}
+		text := strings.TrimSuffix(t.partial+s, "\n")
+		t.partial = ""
+		l := parseLine(text, t.stream)
+		if l.Time.IsZero() {
+			l.Time = t.last
+		}
+		t.last = l.Time
+		if err := fn(l); err != nil {
+			return err
+		}
+	}
+}
+
+func (t *tailer) poll(fn func(Line) error) error {
+	if err := t.drain(fn); err != nil {
+		return err
+	}
+	fi, err := os.Stat(t.path)
+	if err != nil {
+		return nil
+	}
+	if t.f == nil {
+		t.reopen(false)
+		return t.drain(fn)
+	}
+	cur, err := t.f.Stat()
+	if err != nil {
+		return nil
+	}
+	switch {
+	case !os.SameFile(fi, cur):
+		// 已被切割: 旧文件读完后打开新文件
+		if err := t.drain(fn); err != nil {
+			return err
+		}
+		t.partial = ""
+		t.reopen(false)
+		return t.drain(fn)
+	case fi.Size() < t.offset():
+		// 被截断
+		t.partial = ""
+		t.f.Seek(0, io.SeekStart)
+		t.r.Reset(t.f)
+		return t.drain(fn)
+	}
+	return nil
+}
+
+func (t *tailer) offset() int64 {
+	pos, err := t.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0
+	}
+	return pos - int64(t.r.Buffered())
+}
@@ -0,0 +1,181 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// 按时间切割的周期
+const (
+	RotateNone   = ""
+	RotateDaily  = "daily"
+	RotateHourly = "hourly"
+)
+
+// Rotation 是日志文件的切割策略
+type Rotation struct {
+	MaxSize    int    // 单个文件上限, MB
+	MaxBackups int    // 保留的旧文件个数, 0 表示不限
+	MaxAge     int    // 旧文件保留天数, 0 表示不限
+	Compress   bool   // 是否 gzip 压缩旧文件
+	Interval   string // RotateDaily / RotateHourly, 为空时只按大小切割
+}
+
+// ParseInterval validates a rotation interval ("daily", "hourly" or "none").
+func ParseInterval(s string) (string, error) {
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case "", "none", "no":
+		return RotateNone, nil
+	case RotateDaily, RotateHourly:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown rotation interval %q", s)
+}
+
+// Defaults 是 config.yml 中的全局日志配置, 服务没有单独配置的项使用这里的值
+type Defaults struct {
+	Dir      string
+	Rotation Rotation
+	Format   string
+}
+
+// builtin 是 config.yml 没有配置 log 段时的默认值
+var builtin = Defaults{
+	Dir: "/etc/super/logs",
+	Rotation: Rotation{
+		MaxSize:    10,
+		MaxBackups: 5,
+		MaxAge:     7,
+		Compress:   true,
+	},
+	Format: FormatRaw,
+}
+
+var (
+	defaultsMu sync.Mutex
+	defaults   = builtin
+)
+
+// BuiltinDefaults returns the defaults used when config.yml has no log section.
+func BuiltinDefaults() Defaults {
+	return builtin
+}
+
+// SetDefaults replaces the global defaults; empty Dir, Format and non-positive MaxSize keep the built-in values.
+func SetDefaults(d Defaults) {
+	defaultsMu.Lock()
+	defer defaultsMu.Unlock()
+	if d.Dir == "" {
+		d.Dir = builtin.Dir
+	}
+	if d.Rotation.MaxSize <= 0 {
+		d.Rotation.MaxSize = builtin.Rotation.MaxSize
+	}
+	if d.Format == "" {
+		d.Format = builtin.Format
+	}
+	defaults = d
+}
+
+// GetDefaults returns the current global defaults.
+func GetDefaults() Defaults {
+	defaultsMu.Lock()
+	defer defaultsMu.Unlock()
+	return defaults
+}
+
+// 同一个文件只保留一个 lumberjack.Logger, 避免每次重启泄漏文件句柄,
+// 也让 stdout 和 stderr 指向同一文件时共享轮转状态
+type rotatingFile struct {
+	*lumberjack.Logger
+	rotation Rotation
+	period   string // 当前时间周期, 变化时触发切割
+}
+
+var (
+	mu          sync.Mutex
+	loggers     = make(map[string]*rotatingFile)
+	rotatorOnce sync.Once
+)
+
+// rotating 返回 path 对应的 logger. 切割策略变化时换一个新的 logger,
+// 旧的由 release 在不再被引用后关闭.
+func rotating(path string, rot Rotation, truncate bool) (*rotatingFile, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if l, ok := loggers[path]; ok && l.rotation == rot {
+		if truncate {
+			l.Close()
+			os.Truncate(path, 0)
+		}
+		return l, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if truncate {
+		os.Truncate(path, 0)
+	}
+	l := &rotatingFile{
+		Logger: &lumberjack.Logger{
+			Filename:   path,
+			MaxSize:    rot.MaxSize, // megabytes
+			MaxBackups: rot.MaxBackups,
+			MaxAge:     rot.MaxAge, // days
+			Compress:   rot.Compress,
+		},
+		rotation: rot,
+		period:   period(rot.Interval, time.Now()),
+	}
+	loggers[path] = l
+	if rot.Interval != RotateNone {
+		rotatorOnce.Do(func() { go rotateLoop() })
+	}
+	return l, nil
+}
+
+// release 关闭不再被任何服务使用的 logger
+func release(l *rotatingFile) {
+	mu.Lock()
+	defer mu.Unlock()
+	if loggers[l.Filename] == l && inUse(l) {
+		return
+	}
+	if loggers[l.Filename] == l {
+		delete(loggers, l.Filename)
+	}
+	l.Close()
+}
+
+func period(interval string, t time.Time) string {
+	switch interval {
+	case RotateDaily:
+		return t.Format("2006-01-02")
+	case RotateHourly:
+		return t.Format("2006-01-02T15")
+	}
+	return ""
+}
+
+// rotateLoop 每分钟检查一次, 跨过天/小时边界的文件执行切割
+func rotateLoop() {
+	for now := range time.Tick(time.Minute) {
+		mu.Lock()
+		for _, l := range loggers {
+			if l.rotation.Interval == RotateNone {
+				continue
+			}
+			if p := period(l.rotation.Interval, now); p != l.period {
+				l.period = p
+				l.Rotate()
+			}
+		}
+		mu.Unlock()
+	}
+}
@@ -0,0 +1,173 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// 日志行格式, 对应 [Log] 中的 Format=
+const (
+	FormatRaw  = "raw"  // 默认: 原样写入
+	FormatText = "text" // <RFC3339 时间> <stream>[<pid>]: <line>
+	FormatJSON = "json" // 每行一个 JSON 对象
+)
+
+// ParseFormat validates a Format= value.
+func ParseFormat(s string) (string, error) {
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case FormatRaw, FormatText, FormatJSON:
+		return s, nil
+	case "", "none":
+		return FormatRaw, nil
+	}
+	return "", fmt.Errorf("unknown log format %q", s)
+}
+
+const (
+	// maxLine 之后的内容拆成新的一行, 避免没有换行符的输出无限占用内存
+	maxLine = 64 * 1024
+	// 不完整的行最多缓存这么久, 之后按 partial 写出, 不等待换行符
+	partialFlush = time.Second
+	timeLayout   = "2006-01-02T15:04:05.000Z07:00" // RFC3339, 毫秒精度
+)
+
+// stream 是交给子进程的输出目标, reload 时原地替换底层 writer, 进程无需重启.
+// 格式不是 raw 时按行加上时间、流名和 PID.
+type stream struct {
+	mu      sync.Mutex
+	w       io.Writer // nil 表示丢弃
+	kind    string    // "stdout" / "stderr"
+	format  string
+	pid     int
+	partial []byte
+	timer   *time.Timer
+
+	tail        *tail // 与同一服务的另一个流共用
+	tailPartial []byte
+}
+
+// jsonLine 是 FormatJSON 的一行
+type jsonLine struct {
+	Time    string `json:"time"`
+	Stream  string `json:"stream"`
+	PID     int    `json:"pid,omitempty"`
+	Line    string `json:"line"`
+	Partial bool   `json:"partial,omitempty"`
+}
+
+func (s *stream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w == nil {
+		return len(p), nil
+	}
+	s.recordTail(p)
+	if s.format == FormatRaw || s.format == "" {
+		return s.w.Write(p)
+	}
+
+	s.partial = append(s.partial, p...)
+	var out []byte
+	for {
+		i := bytes.IndexByte(s.partial, '\n')
+		if i < 0 {
+			break
+		}
+		out = s.frame(out, s.partial[:i], false)
+		s.partial = s.partial[i+1:]
+	}
+	for len(s.partial) >= maxLine {
+		n := maxLine
+		// 不在 UTF-8 字符中间切开
+		for n > maxLine-utf8.UTFMax && !utf8.RuneStart(s.partial[n]) {
+			n--
+		}
+		out = s.frame(out, s.partial[:n], true)
+		s.partial = s.partial[n:]
+	}
+	s.partial = append([]byte(nil), s.partial...)
+	if len(s.partial) > 0 && s.timer == nil {
+		s.timer = time.AfterFunc(partialFlush, s.flushPartial)
+	}
+
+	if len(out) == 0 {
+		return len(p), nil
+	}
+	if _, err := s.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// frame 把一行按当前格式追加到 out
+func (s *stream) frame(out, line []byte, partial bool) []byte {
+	now := time.Now().Format(timeLayout)
+	if s.format == FormatJSON {
+		data, err := json.Marshal(jsonLine{Time: now, Stream: s.kind, PID: s.pid, Line: string(line), Partial: partial})
+		if err != nil {
+			return out
+		}
+		return append(append(out, data...), '\n')
+	}
+	out = append(out, now...)
+	out = append(out, ' ')
+	out = append(out, s.kind...)
+	if s.pid > 0 {
+		out = append(out, '[')
+		out = strconv.AppendInt(out, int64(s.pid), 10)
+		out = append(out, ']')
+	}
+	out = append(out, ": "...)
+	out = append(out, line...)
+	return append(out, '\n')
+}
+
+// flushPartial 写出缓存中尚未遇到换行符的内容
+func (s *stream) flushPartial() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushLocked()
+}
+
+func (s *stream) flushLocked() {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if len(s.partial) == 0 {
+		return
+	}
+	line := s.partial
+	s.partial = nil
+	if s.w != nil && s.format != FormatRaw {
+		s.w.Write(s.frame(nil, line, true))
+	} else if s.w != nil {
+		s.w.Write(line)
+	}
+}
+
+// swap 替换底层 writer 和格式, 先把旧格式下缓存的内容写到旧 writer
+func (s *stream) swap(w io.Writer, format string) io.Writer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushLocked()
+	old := s.w
+	s.w = w
+	s.format = format
+	return old
+}
+
+func (s *stream) setPID(pid int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushLocked()
+	s.flushTailLocked()
+	s.pid = pid
+}
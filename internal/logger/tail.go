@@ -0,0 +1,123 @@
+package logger
+
+import (
+	"bytes"
+	"sync"
+)
+
+const (
+	// tailLines 是每个服务在内存中保留的最近输出行数, 用于失败事件
+	tailLines = 20
+	// tailLineMax 之后的内容被截掉
+	tailLineMax = 512
+)
+
+// tail 保存一个服务最近的输出行, stdout 和 stderr 按写入顺序混在一起.
+// 与日志文件无关, 输出写到 syslog 或 journal 时同样可用.
+type tail struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+func (t *tail) add(line []byte) {
+	if len(line) > tailLineMax {
+		line = line[:tailLineMax]
+	}
+	line = bytes.TrimRight(line, "\r")
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.lines == nil {
+		t.lines = make([]string, tailLines)
+	}
+	t.lines[t.next] = string(line)
+	t.next = (t.next + 1) % tailLines
+	if t.next == 0 {
+		t.full = true
+	}
+}
+
+func (t *tail) snapshot() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.full {
+		return append([]string(nil), t.lines[:t.next]...)
+	}
+	return append(append([]string(nil), t.lines[t.next:]...), t.lines[:t.next]...)
+}
+
+var (
+	tailsMu sync.Mutex
+	tails   = make(map[string]*tail)
+)
+
+func tailFor(name string) *tail {
+	tailsMu.Lock()
+	defer tailsMu.Unlock()
+	t, ok := tails[name]
+	if !ok {
+		t = &tail{}
+		tails[name] = t
+	}
+	return t
+}
+
+// recordTail 把写入的内容按行记入服务的 tail; 没有换行符的部分先缓存在 s.tailPartial
+func (s *stream) recordTail(p []byte) {
+	if s.tail == nil {
+		return
+	}
+	s.tailPartial = append(s.tailPartial, p...)
+	for {
+		i := bytes.IndexByte(s.tailPartial, '\n')
+		if i < 0 {
+			break
+		}
+		s.tail.add(s.tailPartial[:i])
+		s.tailPartial = s.tailPartial[i+1:]
+	}
+	if len(s.tailPartial) > tailLineMax {
+		s.tail.add(s.tailPartial)
+		s.tailPartial = nil
+	}
+	s.tailPartial = append([]byte(nil), s.tailPartial...)
+}
+
+// flushTailLocked 把上一个进程没有换行符的最后一行记入 tail, 不与新进程的输出连在一起
+func (s *stream) flushTailLocked() {
+	if s.tail != nil && len(s.tailPartial) > 0 {
+		s.tail.add(s.tailPartial)
+	}
+	s.tailPartial = nil
+}
+
+// Tail returns the most recent output lines of a service (stdout and stderr
+// interleaved, oldest first), including a trailing line without a newline.
+// Output discarded by StandardOutput=null is not recorded.
+func Tail(name string) []string {
+	tailsMu.Lock()
+	t, ok := tails[name]
+	tailsMu.Unlock()
+	if !ok {
+		return nil
+	}
+	lines := t.snapshot()
+	for _, kind := range []string{"stdout", "stderr"} {
+		streamsMu.Lock()
+		s, ok := streams[name+"/"+kind]
+		streamsMu.Unlock()
+		if !ok {
+			continue
+		}
+		s.mu.Lock()
+		if len(s.tailPartial) > 0 {
+			lines = append(lines, string(s.tailPartial))
+		}
+		s.mu.Unlock()
+	}
+	if len(lines) > tailLines {
+		lines = lines[len(lines)-tailLines:]
+	}
+	return lines
+}
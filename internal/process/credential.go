@@ -0,0 +1,158 @@
+package process
+
+import (
+  "fmt"
+  "os"
+  "os/exec"
+  "os/user"
+  "strconv"
+  "syscall"
+)
+
+// Credential 对应 unit 文件中的 User= / Group= / SupplementaryGroups= / UMask=
+type Credential struct {
+  User                string
+  Group               string
+  SupplementaryGroups []string
+  UMask               string // 八进制, 如 "0022"; 为空表示继承 superd 的 umask
+}
+
+// ParseUMask validates an octal UMask= value.
+func ParseUMask(s string) (int, error) {
+  n, err := strconv.ParseUint(s, 8, 32)
+  if err != nil || n > 0777 {
+    return 0, fmt.Errorf("invalid UMask %q", s)
+  }
+  return int(n), nil
+}
+
+// apply 解析用户和组, 设置子进程的 uid/gid 及 HOME/USER/LOGNAME, 返回需要追加的环境变量
+func (c Credential) apply(cmd *exec.Cmd) ([]string, error) {
+  if c.User == "" && c.Group == "" && len(c.SupplementaryGroups) == 0 {
+    return nil, nil
+  }
+
+  cred := &syscall.Credential{
+    Uid: uint32(os.Getuid()),
+    Gid: uint32(os.Getgid()),
+  }
+  var env []string
+  if c.User != "" {
+    u, err := lookupUser(c.User)
+    if err != nil {
+      return nil, err
+    }
+    uid, _ := strconv.Atoi(u.Uid)
+    gid, _ := strconv.Atoi(u.Gid)
+    cred.Uid = uint32(uid)
+    cred.Gid = uint32(gid)
+    env = append(env, "HOME="+u.HomeDir, "USER="+u.Username, "LOGNAME="+u.Username)
+    // 与 systemd 的 initgroups() 一致: 先加入用户所属的组, SupplementaryGroups= 在此基础上追加
+    ids, err := u.GroupIds()
+    if err != nil {
+      return nil, fmt.Errorf("groups of user %q: %v", c.User, err)
+    }
+    for _, id := range ids {
+      if n, err := strconv.Atoi(id); err == nil {
+        cred.Groups = append(cred.Groups, uint32(n))
+      }
+    }
+  }
+  if c.Group != "" {
+    gid, err := lookupGroup(c.Group)
+    if err != nil {
+      return nil, err
+    }
+    cred.Gid = gid
+  }
+  for _, g := range c.SupplementaryGroups {
+    gid, err := lookupGroup(g)
+    if err != nil {
+      return nil, err
+    }
+    cred.Groups = append(cred.Groups, gid)
+  }
+  // 只指定了 Group= 时: 仍以 superd 的用户运行则保留附加组, 否则清空, 不继承 superd 的附加组
+  if cred.Groups == nil {
+    cred.NoSetGroups = cred.Uid == uint32(os.Getuid())
+  }
+
+  if cmd.SysProcAttr == nil {
+    cmd.SysProcAttr = &syscall.SysProcAttr{}
+  }
+  cmd.SysProcAttr.Credential = cred
+  return env, nil
+}
+
+func lookupUser(name string) (*user.User, error) {
+  u, err := user.Lookup(name)
+  if err != nil {
+    if _, convErr := strconv.Atoi(name); convErr == nil {
+      if u, err2 := user.LookupId(name); err2 == nil {
+        return u, nil
+      }
+    }
+    return nil, fmt.Errorf("user %q does not exist: %v", name, err)
+  }
+  return u, nil
+}
+
+func lookupGroup(name string) (uint32, error) {
+  g, err := user.LookupGroup(name)
+  if err != nil {
+    if n, convErr := strconv.Atoi(name); convErr == nil {
+      return uint32(n), nil
+    }
+    return 0, fmt.Errorf("group %q does not exist: %v", name, err)
+  }
+  gid, _ := strconv.Atoi(g.Gid)
+  return uint32(gid), nil
+}
+
+// LookupUID resolves a user name or numeric uid.
+func LookupUID(name string) (uint32, error) {
+  u, err := lookupUser(name)
+  if err != nil {
+    return 0, err
+  }
+  uid, _ := strconv.Atoi(u.Uid)
+  return uint32(uid), nil
+}
+
+// LookupGID resolves a group name or numeric gid.
+func LookupGID(name string) (uint32, error) {
+  return lookupGroup(name)
+}
+
+// umaskScript 在子进程中设置 umask 后 exec 真正的程序, superd 自身的 umask 不受影响.
+// umaskArgv0Script 中 $0 是传给程序的 argv[0], $1 是程序路径
+const (
+  umaskScript      = `umask %04o && exec "$@"`
+  umaskArgv0Script = `umask %04o && p="$1" && shift && exec -a "$0" "$p" "$@"`
+)
+
+// applyUMask 改为经 sh 启动 cmd, 由子进程自己设置 umask; 返回程序 exec 后的 argv.
+// sh 的 exec 以程序路径作为 argv[0]; argv0 为 true(指定了 argv[0])时改用 bash 的 exec -a
+func applyUMask(cmd *exec.Cmd, mask string, argv0 bool) ([]string, error) {
+  argv := append([]string(nil), cmd.Args...)
+  if mask == "" {
+    return argv, nil
+  }
+  m, err := ParseUMask(mask)
+  if err != nil {
+    return nil, err
+  }
+  if !argv0 {
+    argv[0] = cmd.Path
+    cmd.Args = append([]string{"sh", "-c", fmt.Sprintf(umaskScript, m), "sh"}, argv...)
+    cmd.Path = "/bin/sh"
+    return argv, nil
+  }
+  bash, err := exec.LookPath("bash")
+  if err != nil {
+    return nil, fmt.Errorf("UMask= with a custom argv[0] requires bash: %v", err)
+  }
+  cmd.Args = append([]string{"bash", "-c", fmt.Sprintf(umaskArgv0Script, m), argv[0], cmd.Path}, argv[1:]...)
+  cmd.Path = bash
+  return argv, nil
+}
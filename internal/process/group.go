@@ -0,0 +1,109 @@
+package process
+
+import (
+  "fmt"
+  "strings"
+  "syscall"
+  "time"
+
+  "github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+// KillMode 对应 unit 文件中的 KillMode=, 决定停止时向哪些进程发送信号
+type KillMode string
+
+const (
+  KillControlGroup KillMode = "control-group" // 整个进程组都收到 KillSignal 和 SIGKILL
+  KillProcess      KillMode = "process"       // 只处理主进程
+  KillMixed        KillMode = "mixed"         // KillSignal 发给主进程, SIGKILL 发给整个进程组
+  KillNone         KillMode = "none"          // 只向主进程发送 KillSignal, 不升级也不清理子进程
+)
+
+// ParseKillMode validates a KillMode= value.
+func ParseKillMode(s string) (KillMode, error) {
+  switch m := KillMode(strings.TrimSpace(s)); m {
+  case KillControlGroup, KillProcess, KillMixed, KillNone:
+    return m, nil
+  }
+  return "", fmt.Errorf("unknown KillMode %q", s)
+}
+
+// groupSysProcAttr 让子进程运行在独立的 session/进程组中, pgid == pid
+func groupSysProcAttr() *syscall.SysProcAttr {
+  return &syscall.SysProcAttr{Setsid: true}
+}
+
+// signalGroup 向整个进程组发送信号, 进程组已不存在时忽略
+func signalGroup(pgid int, sig syscall.Signal) error {
+  if err := syscall.Kill(-pgid, sig); err != nil && err != syscall.ESRCH {
+    return err
+  }
+  return nil
+}
+
+func groupAlive(pgid int) bool {
+  err := syscall.Kill(-pgid, 0)
+  return err == nil || err == syscall.EPERM
+}
+
+// waitGroupGone 等待进程组内所有进程退出, timeout 为 0 时一直等待
+func waitGroupGone(pgid int, timeout time.Duration) bool {
+  var deadline time.Time
+  if timeout > 0 {
+    deadline = time.Now().Add(timeout)
+  }
+  for groupAlive(pgid) {
+    if !deadline.IsZero() && time.Now().After(deadline) {
+      return false
+    }
+    time.Sleep(100 * time.Millisecond)
+  }
+  return true
+}
+
+// signalStop sends the initial KillSignal according to the kill mode.
+func signalStop(pid int, policy StopPolicy) error {
+  if policy.KillMode == KillControlGroup {
+    return signalGroup(pid, policy.Signal)
+  }
+  return syscall.Kill(pid, policy.Signal)
+}
+
+// signalKill sends the escalating SIGKILL according to the kill mode.
+func signalKill(pid int, policy StopPolicy) error {
+  switch policy.KillMode {
+  case KillControlGroup, KillMixed:
+    return signalGroup(pid, syscall.SIGKILL)
+  }
+  if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+    return err
+  }
+  return nil
+}
+
+// cleanupGroup 在主进程退出后清理同一进程组中残留的子进程.
+// signalled 表示进程组已经收到过 KillSignal(Stop 路径), 不再重复发送.
+func cleanupGroup(name string, pgid int, policy StopPolicy, signalled bool) {
+  if !groupAlive(pgid) {
+    return
+  }
+  switch policy.KillMode {
+  case KillControlGroup:
+    if !signalled {
+      hlog.Infof("%s: sending %s to leftover processes in group %d", name, SignalName(policy.Signal), pgid)
+      if err := signalGroup(pgid, policy.Signal); err != nil {
+        hlog.Errorf("%s: signal group %d failed: %v", name, pgid, err)
+      }
+    }
+    if waitGroupGone(pgid, policy.Timeout) || !policy.SendSIGKILL {
+      return
+    }
+  case KillMixed:
+  default:
+    return
+  }
+  hlog.Warnf("%s: killing leftover processes in group %d", name, pgid)
+  if err := signalGroup(pgid, syscall.SIGKILL); err != nil {
+    hlog.Errorf("%s: kill group %d failed: %v", name, pgid, err)
+  }
+}
@@ -0,0 +1,111 @@
+package process
+
+import (
+  "time"
+
+  "github.com/litongjava/supers/internal/events"
+  "github.com/litongjava/supers/internal/protocol"
+)
+
+// State 是服务的运行状态
+type State string
+
+const (
+  StateInactive State = "inactive" // 未启动, 或已被手动停止
+  StateStarting State = "starting" // 正在启动进程
+  StateRunning  State = "running"
+  StateStopping State = "stopping" // 已发送停止信号, 等待退出
+  StateBackoff  State = "backoff"  // 已退出, 等待 RestartSec / 退避时间后重启
+  StateFailed   State = "failed"   // 启动失败、异常退出且不重启, 或达到启动频率限制
+  StateExited   State = "exited"   // 正常退出且不重启
+)
+
+// maxTransitions 是每个服务保留的状态变化记录条数
+const maxTransitions = 20
+
+func (r *registry) transition(name string, to State, now time.Time) (State, bool) {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+  return r.transitionLocked(name, to, now)
+}
+
+func (r *registry) transitionLocked(name string, to State, now time.Time) (State, bool) {
+  from, ok := r.states[name]
+  if !ok {
+    from = StateInactive
+  }
+  r.states[name] = to
+  if from == to {
+    return from, false
+  }
+  list := append(r.transitions[name], protocol.Transition{From: string(from), To: string(to), At: now})
+  if len(list) > maxTransitions {
+    list = append([]protocol.Transition(nil), list[len(list)-maxTransitions:]...)
+  }
+  r.transitions[name] = list
+  return from, true
+}
+
+// claimStart 在服务没有运行时把状态切换为 starting; 已在启动、运行或停止中时不切换, 返回当前状态和 false.
+// only 不为空时只从该状态切换, 用于自动重启: 退避期间被手动启动或停止的服务不再重启
+func (r *registry) claimStart(name string, only State, now time.Time) (State, bool) {
+  r.mu.Lock()
+  cur, ok := r.states[name]
+  if !ok {
+    cur = StateInactive
+  }
+  if (only != "" && cur != only) || cur == StateStarting || cur == StateRunning || cur == StateStopping {
+    r.mu.Unlock()
+    return cur, false
+  }
+  from, changed := r.transitionLocked(name, StateStarting, now)
+  r.mu.Unlock()
+  if changed {
+    emitStateChanged(name, from, StateStarting)
+  }
+  return cur, true
+}
+func (r *registry) getState(name string) (State, bool) {
+  r.mu.RLock()
+  s, ok := r.states[name]
+  r.mu.RUnlock()
+  return s, ok
+}
+func (r *registry) getTransitions(name string) []protocol.Transition {
+  r.mu.RLock()
+  list := append([]protocol.Transition(nil), r.transitions[name]...)
+  r.mu.RUnlock()
+  return list
+}
+func (r *registry) setStartError(name, msg string) {
+  r.mu.Lock()
+  r.startErrors[name] = msg
+  r.mu.Unlock()
+}
+func (r *registry) getStartError(name string) string {
+  r.mu.RLock()
+  msg := r.startErrors[name]
+  r.mu.RUnlock()
+  return msg
+}
+
+// setState 切换服务状态并发出 process.state_changed 事件
+func setState(name string, to State) {
+  from, changed := reg.transition(name, to, time.Now())
+  if changed {
+    emitStateChanged(name, from, to)
+  }
+}
+
+func emitStateChanged(name string, from, to State) {
+  e := events.Event{Name: name, Type: events.EventProcessStateChanged, State: string(to), PrevState: string(from)}
+  if c, ok := reg.getProc(name); ok && c.Process != nil && (to == StateRunning || to == StateStopping) {
+    e.PID = c.Process.Pid
+  }
+  events.Emit(e)
+}
+
+// GetState returns the current state of a service; ok is false for unknown services.
+func GetState(name string) (State, bool) {
+  return reg.getState(name)
+}
@@ -6,19 +6,74 @@ import (
   "os/exec"
   "strings"
   "sync"
+  "syscall"
   "time"
+  "unicode/utf8"
 
   "github.com/cloudwego/hertz/pkg/common/hlog"
   "github.com/litongjava/supers/internal/events"
   "github.com/litongjava/supers/internal/logger"
+  "github.com/litongjava/supers/internal/protocol"
 )
 
 type RestartPolicy struct {
-  MaxRetries    int
-  Delay         time.Duration
-  RestartOnZero bool
+  Mode     RestartMode   // Restart=
+  Delay    time.Duration // RestartSec=
+  MaxDelay time.Duration // RestartMaxDelaySec=: 大于 Delay 时启用指数退避
+  Steps    int           // RestartSteps=: 从 Delay 增长到 MaxDelay 的步数, 0 表示每次翻倍
+  Jitter   time.Duration // X-Super-RestartJitterSec=: 在退避时间上叠加 [0, Jitter) 的随机值
+
+  // StartLimitIntervalSec= / StartLimitBurst=: Interval 内启动次数达到 Burst 时放弃重启, Interval 为 0 表示不限制
+  StartLimitInterval time.Duration
+  StartLimitBurst    int
+
+  PreventExitStatus ExitStatusSet // RestartPreventExitStatus=: 命中时不重启
+  SuccessExitStatus ExitStatusSet // SuccessExitStatus=: 额外视为成功的退出码/信号
+  ForceExitStatus   ExitStatusSet // RestartForceExitStatus=: 命中时无视 Restart= 强制重启
 }
 
+// StopPolicy 描述如何停止进程: 先发送 Signal, 等待 Timeout 后按需升级为 SIGKILL
+type StopPolicy struct {
+  Signal      syscall.Signal // KillSignal=, 默认 SIGTERM
+  Timeout     time.Duration  // TimeoutStopSec=, 0 表示无限等待
+  SendSIGKILL bool           // SendSIGKILL=, 超时后是否发送 SIGKILL
+  KillMode    KillMode       // KillMode=, 默认 control-group
+}
+
+// DefaultStopPolicy mirrors systemd defaults.
+func DefaultStopPolicy() StopPolicy {
+  return StopPolicy{
+    Signal:      syscall.SIGTERM,
+    Timeout:     90 * time.Second,
+    SendSIGKILL: true,
+    KillMode:    KillControlGroup,
+  }
+}
+
+// Spec 描述一个受管进程的启动、重启与停止方式
+type Spec struct {
+  Cmd              []string // Cmd[0] 为可执行文件
+  Argv0            string   // 非空时替换传给进程的 argv[0]
+  IgnoreFailure    bool     // 非零退出码按成功处理("-" 前缀)
+  WorkingDirectory string
+  OptionalWorkDir  bool // WorkingDirectory= 带 "-" 前缀: 目录不存在时在 superd 的工作目录中启动
+  Env              []string
+  Restart          RestartPolicy
+  Stop             StopPolicy
+  Credential       Credential
+  Log              logger.Options
+}
+
+// stop outcomes reported by Stop and the process.exited event
+const (
+  StopGraceful   = "graceful"
+  StopKilled     = "killed"
+  StopNotRunning = "not running" // 进程已退出(例如正在等待重启), 只记录停止标志
+)
+
+// killWait is how long Stop waits for the exit after SIGKILL.
+const killWait = 5 * time.Second
+
 // ---- concurrency-safe registry ----
 
 type registry struct {
@@ -28,9 +83,18 @@ type registry struct {
   workingDirs map[string]string
   startTimes  map[string]time.Time
   commands    map[string]string
-  policies    map[string]RestartPolicy // ⭐ 新增:保存重启策略
-  cmdLines    map[string][]string      // ⭐ 新增:保存命令行
-  envs        map[string][]string      // ⭐ 新增:保存环境变量
+  specs       map[string]Spec // ⭐ 保存启动规格(命令行/环境变量/重启与停止策略)
+  escalated   map[string]bool // Stop 是否已升级为 SIGKILL
+  starts      map[string][]time.Time // 最近的启动时间, 用于 StartLimitBurst
+  restarts    map[string]int         // 连续自动重启次数, 用于计算退避
+  exited      map[string]bool        // 主进程已退出
+  procStarts  map[string]uint64      // /proc 启动时间, 用于持久化后识别进程
+  argvs       map[string][]string    // 实际执行的 argv
+  lastExits   map[string]ExitStatus  // 最近一次退出状态
+  states      map[string]State       // 当前状态
+  transitions map[string][]protocol.Transition
+  startErrors map[string]string // 最近一次启动失败的原因
+  outputs     map[string]*sync.WaitGroup // 复制当前进程输出的 goroutine
 }
 
 func newRegistry() *registry {
@@ -40,9 +104,18 @@ func newRegistry() *registry {
     workingDirs: make(map[string]string),
     startTimes:  make(map[string]time.Time),
     commands:    make(map[string]string),
-    policies:    make(map[string]RestartPolicy),
-    cmdLines:    make(map[string][]string),
-    envs:        make(map[string][]string),
+    specs:       make(map[string]Spec),
+    escalated:   make(map[string]bool),
+    starts:      make(map[string][]time.Time),
+    restarts:    make(map[string]int),
+    exited:      make(map[string]bool),
+    procStarts:  make(map[string]uint64),
+    argvs:       make(map[string][]string),
+    lastExits:   make(map[string]ExitStatus),
+    states:      make(map[string]State),
+    transitions: make(map[string][]protocol.Transition),
+    startErrors: make(map[string]string),
+    outputs:     make(map[string]*sync.WaitGroup),
   }
 }
 
@@ -113,46 +186,223 @@ func (r *registry) snapshotProcNames() []string {
   r.mu.RUnlock()
   return names
 }
-func (r *registry) setMetadata(name string, cmdLine []string, workDir string, policy RestartPolicy, env []string) {
+func (r *registry) setMetadata(name string, spec Spec) {
+  r.mu.Lock()
+  r.specs[name] = spec
+  r.workingDirs[name] = spec.WorkingDirectory
+  r.mu.Unlock()
+}
+func (r *registry) getMetadata(name string) (Spec, bool) {
+  r.mu.RLock()
+  spec, ok := r.specs[name]
+  r.mu.RUnlock()
+  return spec, ok && len(spec.Cmd) > 0
+}
+func (r *registry) setEscalated(name string, v bool) {
+  r.mu.Lock()
+  r.escalated[name] = v
+  r.mu.Unlock()
+}
+func (r *registry) isEscalated(name string) bool {
+  r.mu.RLock()
+  v := r.escalated[name]
+  r.mu.RUnlock()
+  return v
+}
+// recordStart 记录一次启动尝试, 并丢弃 interval 之前的记录
+func (r *registry) recordStart(name string, now time.Time, interval time.Duration) {
+  r.mu.Lock()
+  kept := r.starts[name][:0]
+  for _, t := range r.starts[name] {
+    if interval > 0 && now.Sub(t) < interval {
+      kept = append(kept, t)
+    }
+  }
+  r.starts[name] = append(kept, now)
+  r.mu.Unlock()
+}
+// startLimitHit 判断 interval 内的启动次数是否已达到 burst
+func (r *registry) startLimitHit(name string, now time.Time, interval time.Duration, burst int) bool {
+  if interval <= 0 || burst <= 0 {
+    return false
+  }
+  r.mu.RLock()
+  n := 0
+  for _, t := range r.starts[name] {
+    if now.Sub(t) < interval {
+      n++
+    }
+  }
+  r.mu.RUnlock()
+  return n >= burst
+}
+func (r *registry) nextRestart(name string) int {
+  r.mu.Lock()
+  n := r.restarts[name]
+  r.restarts[name] = n + 1
+  r.mu.Unlock()
+  return n
+}
+// resetRestarts 清空退避计数; 手动启动时同时清除启动记录, 使 failed 的服务可以重新启动
+func (r *registry) resetRestarts(name string, manual bool) {
+  r.mu.Lock()
+  delete(r.restarts, name)
+  if manual {
+    delete(r.starts, name)
+  }
+  r.mu.Unlock()
+}
+func (r *registry) setExited(name string, v bool) {
+  r.mu.Lock()
+  r.exited[name] = v
+  r.mu.Unlock()
+}
+func (r *registry) isExited(name string) bool {
+  r.mu.RLock()
+  v := r.exited[name]
+  r.mu.RUnlock()
+  return v
+}
+func (r *registry) setLastExit(name string, s ExitStatus) {
+  r.mu.Lock()
+  r.lastExits[name] = s
+  r.mu.Unlock()
+}
+func (r *registry) getLastExit(name string) (ExitStatus, bool) {
+  r.mu.RLock()
+  s, ok := r.lastExits[name]
+  r.mu.RUnlock()
+  return s, ok
+}
+func (r *registry) getRestarts(name string) int {
+  r.mu.RLock()
+  n := r.restarts[name]
+  r.mu.RUnlock()
+  return n
+}
+func (r *registry) getArgv(name string) []string {
+  r.mu.RLock()
+  argv := r.argvs[name]
+  r.mu.RUnlock()
+  return argv
+}
+func (r *registry) setProcInfo(name string, procStart uint64, argv []string) {
   r.mu.Lock()
-  r.cmdLines[name] = cmdLine
-  r.workingDirs[name] = workDir
-  r.policies[name] = policy
-  r.envs[name] = env
+  r.procStarts[name] = procStart
+  r.argvs[name] = argv
   r.mu.Unlock()
 }
-func (r *registry) getMetadata(name string) ([]string, string, RestartPolicy, []string, bool) {
+func (r *registry) setOutput(name string, copies *sync.WaitGroup) {
+  r.mu.Lock()
+  r.outputs[name] = copies
+  r.mu.Unlock()
+}
+func (r *registry) getOutput(name string) *sync.WaitGroup {
   r.mu.RLock()
-  cmd := r.cmdLines[name]
-  dir := r.workingDirs[name]
-  policy := r.policies[name]
-  env := r.envs[name]
-  ok := len(cmd) > 0
+  copies := r.outputs[name]
   r.mu.RUnlock()
-  return cmd, dir, policy, env, ok
+  return copies
+}
+// snapshotState 返回需要持久化的记录: 运行中的进程和被手动停止的服务
+func (r *registry) snapshotState() []savedProc {
+  r.mu.RLock()
+  defer r.mu.RUnlock()
+  list := make([]savedProc, 0, len(r.procs))
+  for name, c := range r.procs {
+    p := savedProc{Name: name, ManualStop: r.manualStop[name]}
+    if !r.exited[name] && c.Process != nil {
+      p.PID = c.Process.Pid
+      p.StartTime = r.startTimes[name]
+      p.ProcStart = r.procStarts[name]
+      p.Cmdline = r.argvs[name]
+    } else if !p.ManualStop {
+      continue
+    }
+    list = append(list, p)
+  }
+  // 从状态文件恢复为停止状态、本次还未启动过的服务
+  for name, stopped := range r.manualStop {
+    if _, ok := r.procs[name]; !ok && stopped {
+      list = append(list, savedProc{Name: name, ManualStop: true})
+    }
+  }
+  return list
+}
+// forget 删除服务的全部记录
+func (r *registry) forget(name string) {
+  r.mu.Lock()
+  delete(r.procs, name)
+  delete(r.manualStop, name)
+  delete(r.workingDirs, name)
+  delete(r.startTimes, name)
+  delete(r.commands, name)
+  delete(r.specs, name)
+  delete(r.escalated, name)
+  delete(r.starts, name)
+  delete(r.restarts, name)
+  delete(r.exited, name)
+  delete(r.procStarts, name)
+  delete(r.argvs, name)
+  delete(r.lastExits, name)
+  delete(r.states, name)
+  delete(r.transitions, name)
+  delete(r.startErrors, name)
+  delete(r.outputs, name)
+  r.mu.Unlock()
 }
 
 // ---- process manager ----
 
-// Manage 同步启动进程,返回 PID 或错误
-func Manage(name string, cmd []string, WorkingDirectory string, policy RestartPolicy, env []string) (int, error) {
+// ActiveError 表示服务已在启动、运行或停止中, Manage 没有启动第二份
+type ActiveError struct {
+  Name  string
+  State State
+  PID   int
+}
+
+func (e *ActiveError) Error() string {
+  return fmt.Sprintf("%s is already %s", e.Name, e.State)
+}
+
+// Manage 同步启动进程,返回 PID 或错误; 服务已在启动、运行或停止中时返回 *ActiveError.
+// 手动启动会清空重启退避计数和启动频率记录, 使 failed 的服务可以重新启动;
+// 处于 backoff 的服务立即启动, 不再等待自动重启.
+func Manage(name string, spec Spec) (int, error) {
+  if state, ok := reg.claimStart(name, "", time.Now()); !ok {
+    e := &ActiveError{Name: name, State: state}
+    if c, ok := reg.getProc(name); ok && c.Process != nil {
+      e.PID = c.Process.Pid
+    }
+    return 0, e
+  }
+  reg.resetRestarts(name, true)
+  return startProcess(name, spec)
+}
+
+// startProcess 启动一次进程, 供 Manage 和自动重启共用
+func startProcess(name string, spec Spec) (int, error) {
   // 保存元数据供重启使用
-  reg.setMetadata(name, cmd, WorkingDirectory, policy, env)
+  reg.setMetadata(name, spec)
+  reg.recordStart(name, time.Now(), spec.Restart.StartLimitInterval)
 
   // 清除手动停止标志(如果是重启)
   reg.setManualStop(name, false)
+  reg.setEscalated(name, false)
+  setState(name, StateStarting)
+
+  cmd := spec.Cmd
+  env := spec.Env
 
-  stdoutW, stderrW, err := logger.SetupLog(name)
+  stdoutW, stderrW, err := logger.SetupLog(name, spec.Log)
   if err != nil {
     hlog.Errorf("logger setup failed for %s: %v", name, err)
   }
 
-  program := name
-  cmdArgs := cmd
-  if len(cmd) > 0 && (strings.HasPrefix(cmd[0], "/") || strings.Contains(cmd[0], "/")) {
-    program = cmd[0]
-    cmdArgs = cmd[1:]
+  if len(cmd) == 0 {
+    return 0, startFailed(name, fmt.Errorf("empty command"))
   }
+  program := cmd[0]
+  cmdArgs := cmd[1:]
 
   // record metadata
   reg.setStartTime(name, time.Now())
@@ -164,62 +414,142 @@ func Manage(name string, cmd []string, WorkingDirectory string, policy RestartPo
 
   hlog.Infof("Starting %s %v", name, cmd)
   c := exec.Command(program, cmdArgs...)
-  if len(env) > 0 {
-    expandedEnv := make([]string, 0, len(env))
-    systemEnv := os.Environ()
-
-    for _, e := range env {
-      // 展开 $VAR 和 ${VAR}
-      expanded := os.ExpandEnv(e)
-      expandedEnv = append(expandedEnv, expanded)
+  if spec.Argv0 != "" {
+    c.Args[0] = spec.Argv0
+  }
+  if spec.WorkingDirectory != "" {
+    c.Dir = spec.WorkingDirectory
+    if _, err := os.Stat(c.Dir); err != nil && spec.OptionalWorkDir {
+      hlog.Warnf("%s: ignoring WorkingDirectory=-%s: %v", name, c.Dir, err)
+      c.Dir = ""
     }
-    c.Env = append(systemEnv, expandedEnv...)
-  } else {
-    c.Env = os.Environ()
   }
 
-  if WorkingDirectory != "" {
-    c.Dir = WorkingDirectory
+  // 开启 FIFO 时经 FIFO 输出, superd 重启后子进程仍可继续写日志
+  copies := &sync.WaitGroup{}
+  stdoutF, err := attachOutput(name, "stdout", stdoutW, copies)
+  if err != nil {
+    hlog.Warnf("%s: attach stdout failed, falling back to exec pipe: %v", name, err)
+  }
+  stderrF, err := attachOutput(name, "stderr", stderrW, copies)
+  if err != nil {
+    hlog.Warnf("%s: attach stderr failed, falling back to exec pipe: %v", name, err)
+  }
+  // nil writer(StandardOutput=null) 时子进程输出到 /dev/null
+  if stdoutW != nil {
+    c.Stdout = stdoutW
+  }
+  if stderrW != nil {
+    c.Stderr = stderrW
+  }
+  if stdoutF != nil {
+    c.Stdout = stdoutF
+    defer stdoutF.Close()
+  }
+  if stderrF != nil {
+    c.Stderr = stderrF
+    defer stderrF.Close()
+  }
+  // 独立进程组, 停止时可以把 fork 出来的子进程一起处理
+  c.SysProcAttr = groupSysProcAttr()
+
+  // 以 User= / Group= 指定的身份运行
+  credEnv, err := spec.Credential.apply(c)
+  if err != nil {
+    return 0, startFailed(name, err)
+  }
+
+  c.Env = append(os.Environ(), credEnv...)
+  for _, e := range env {
+    // 展开 $VAR 和 ${VAR}
+    c.Env = append(c.Env, os.ExpandEnv(e))
   }
 
-  c.Stdout = stdoutW
-  c.Stderr = stderrW
+  // UMask= 由子进程自己设置, 不修改 superd 的 umask
+  argv, err := applyUMask(c, spec.Credential.UMask, spec.Argv0 != "")
+  if err != nil {
+    return 0, startFailed(name, err)
+  }
 
   // ⭐ 同步启动
   if err := c.Start(); err != nil {
-    errMsg := "failed: " + name + " err=" + err.Error()
-    hlog.Error(errMsg)
-    events.Emit(events.Event{
-      Name:  name,
-      Type:  events.EventProcessStartFailed,
-      Error: err.Error(),
-    })
-    return 0, err
+    return 0, startFailed(name, err)
   }
 
   pid := c.Process.Pid
+  logger.SetPID(name, pid)
   events.Emit(events.Event{
     Name: name,
     Type: events.EventProcessStarted,
     PID:  pid,
   })
+  procStart, _ := readProcStart(pid)
+  reg.setProcInfo(name, procStart, argv)
+  reg.setExited(name, false)
   reg.setProc(name, c)
+  reg.setOutput(name, copies)
+  setState(name, StateRunning)
+  saveState()
   hlog.Infof("%s PID=%d", name, pid)
 
   // ⭐ 异步监控进程
-  go monitorProcess(name, c, 0)
+  go monitorProcess(name, c)
 
   return pid, nil
 }
 
+// startFailed 记录启动失败并发出 process.start_failed 事件
+func startFailed(name string, err error) error {
+  hlog.Error("failed: " + name + " err=" + err.Error())
+  reg.setStartError(name, err.Error())
+  setState(name, StateFailed)
+  events.Emit(events.Event{
+    Name:     name,
+    Type:     events.EventProcessStartFailed,
+    Restarts: reg.getRestarts(name),
+    Error:    err.Error(),
+  })
+  return err
+}
+
 // monitorProcess 监控进程退出并处理重启
-func monitorProcess(name string, c *exec.Cmd, retries int) {
+func monitorProcess(name string, c *exec.Cmd) {
   err := c.Wait()
-  exitCode := c.ProcessState.ExitCode()
-  events.Emit(events.Event{Name: name, ExitCode: exitCode, Type: events.EventProcessExited})
+  handleExit(name, c, exitStatusOf(c.ProcessState), err)
+}
 
-  msg := fmt.Sprintf("%s exited code %d", name, exitCode)
-  if err != nil {
+// handleExit 处理主进程退出: 发出事件、清理残留子进程并按 Restart= 决定是否重启
+func handleExit(name string, c *exec.Cmd, status ExitStatus, waitErr error) {
+  // 先读完进程最后的输出, 失败事件的 LogTail 才包含崩溃前的几行
+  waitOutput(name)
+  reg.setExited(name, true)
+  reg.setLastExit(name, status)
+  saveState()
+
+  exitCode := status.Code
+  exited := events.Event{
+    Name:       name,
+    Type:       events.EventProcessExited,
+    ExitCode:   exitCode,
+    PID:        c.Process.Pid,
+    CoreDumped: status.CoreDump,
+    Restarts:   reg.getRestarts(name),
+  }
+  if status.Signal != 0 {
+    exited.Signal = SignalName(status.Signal)
+  }
+  if start, ok := reg.getStartTime(name); ok {
+    exited.UptimeSec = time.Since(start).Round(time.Millisecond).Seconds()
+  }
+  if reg.isManualStop(name) {
+    exited.StopResult = stopResult(name, status)
+  } else if exitFailed(name, status) {
+    exited.LogTail = logger.Tail(name)
+  }
+  events.Emit(exited)
+
+  msg := fmt.Sprintf("%s exited %s", name, status)
+  if waitErr != nil || exitCode != 0 {
     hlog.Errorf(msg)
   } else {
     hlog.Infof(msg)
@@ -227,36 +557,123 @@ func monitorProcess(name string, c *exec.Cmd, retries int) {
 
   if reg.isManualStop(name) {
     hlog.Infof("Process %s was manually stopped; skipping restart", name)
+    setState(name, StateInactive)
     return
   }
 
   // 获取保存的元数据
-  cmd, workDir, policy, env, ok := reg.getMetadata(name)
+  spec, ok := reg.getMetadata(name)
   if !ok {
     hlog.Errorf("Cannot restart %s: metadata not found", name)
     return
   }
+  policy := spec.Restart
 
-  if !shouldRestart(exitCode, retries, policy) {
+  // 主进程意外退出, 清理残留子进程, 避免它们继续占用端口
+  cleanupGroup(name, c.Process.Pid, spec.Stop, false)
+
+  // "-" 前缀: 失败退出码按成功处理
+  if spec.IgnoreFailure && status.Signal == 0 {
+    status.Code = 0
+  }
+  if !shouldRestart(status, policy) {
+    hlog.Infof("%s exited with %s; Restart=%s, not restarting", name, status, policy.Mode)
+    if policy.clean(status) {
+      setState(name, StateExited)
+    } else {
+      setState(name, StateFailed)
+    }
     return
   }
 
-  events.Emit(events.Event{Name: name, ExitCode: exitCode, Type: events.EventProcessRestarted})
-  retries++
-  hlog.Infof("restart %s in %s (retry %d)", name, policy.Delay, retries)
-  time.Sleep(policy.Delay)
+  // 稳定运行超过 stableAfter 后重新从 RestartSec 开始退避
+  if start, ok := reg.getStartTime(name); ok && policy.stableAfter() > 0 && time.Since(start) >= policy.stableAfter() {
+    reg.resetRestarts(name, false)
+  }
+  restart(name, c, spec, exitCode)
+}
 
-  // 重启
-  _, err = Manage(name, cmd, workDir, policy, env)
-  if err != nil {
-    hlog.Errorf("restart %s failed: %v", name, err)
+// exitFailed 判断退出是否算失败: 按 SuccessExitStatus= 和 "-" 前缀, 没有配置时非 0 退出或被信号终止都算失败
+func exitFailed(name string, status ExitStatus) bool {
+  spec, ok := reg.getMetadata(name)
+  if !ok {
+    return status.Code != 0 || status.Signal != 0
   }
+  if spec.IgnoreFailure && status.Signal == 0 {
+    return false
+  }
+  return !spec.Restart.clean(status)
 }
 
-func Stop(name string) error {
+// restart 按退避策略重启退出的进程 exited, 启动失败时继续重试, 直到成功、被手动停止或触发启动频率限制
+func restart(name string, exited *exec.Cmd, spec Spec, exitCode int) {
+  policy := spec.Restart
+  for {
+    if reg.startLimitHit(name, time.Now(), policy.StartLimitInterval, policy.StartLimitBurst) {
+      setState(name, StateFailed)
+      msg := fmt.Sprintf("start limit hit: %d starts within %s", policy.StartLimitBurst, policy.StartLimitInterval)
+      hlog.Errorf("%s: %s; giving up", name, msg)
+      waitOutput(name)
+      events.Emit(events.Event{
+        Name:     name,
+        Type:     events.EventProcessGaveUp,
+        ExitCode: exitCode,
+        Restarts: reg.getRestarts(name),
+        Error:    msg,
+        LogTail:  logger.Tail(name),
+      })
+      return
+    }
+
+    n := reg.nextRestart(name)
+    delay := policy.backoff(n)
+    setState(name, StateBackoff)
+    hlog.Infof("restart %s in %s (retry %d)", name, delay, n+1)
+    time.Sleep(delay)
+
+    if reg.isManualStop(name) {
+      hlog.Infof("Process %s was stopped during restart delay; skipping restart", name)
+      return
+    }
+    if _, ok := reg.getMetadata(name); !ok {
+      hlog.Infof("%s was removed during restart delay; skipping restart", name)
+      return
+    }
+    // 退避期间被手动启动过的服务不再重启; 手动启动的进程又退出时由它自己的 restart 负责
+    if c, ok := reg.getProc(name); ok && c != exited {
+      hlog.Infof("%s was started during restart delay; skipping restart", name)
+      return
+    }
+    if state, ok := reg.claimStart(name, StateBackoff, time.Now()); !ok {
+      hlog.Infof("%s is %s after the restart delay; skipping restart", name, state)
+      return
+    }
+    if pid, err := startProcess(name, spec); err == nil {
+      events.Emit(events.Event{Name: name, ExitCode: exitCode, Type: events.EventProcessRestarted, PID: pid, Restarts: n + 1})
+      return
+    } else {
+      hlog.Errorf("restart %s failed: %v", name, err)
+    }
+  }
+}
+
+// Stop 按 StopPolicy 停止进程: 先发送 KillSignal, 等待 TimeoutStopSec,
+// 超时且 SendSIGKILL 开启时再发送 SIGKILL. 返回 StopGraceful 或 StopKilled.
+func Stop(name string) (string, error) {
   cmd, ok := reg.getProc(name)
   if !ok || cmd.Process == nil {
-    return fmt.Errorf("no process: %s", name)
+    return "", fmt.Errorf("no process: %s", name)
+  }
+  if reg.isExited(name) {
+    // 正在等待重启或已退出: 只需阻止后续重启
+    reg.setManualStop(name, true)
+    setState(name, StateInactive)
+    saveState()
+    return StopNotRunning, nil
+  }
+  policy := DefaultStopPolicy()
+  if spec, ok := reg.getMetadata(name); ok {
+    policy = spec.Stop
   }
 
   // 订阅退出事件
@@ -264,34 +681,86 @@ func Stop(name string) error {
 
   // 设置手动停止标志
   reg.setManualStop(name, true)
+  reg.setEscalated(name, false)
+  setState(name, StateStopping)
+  saveState()
+
+  pid := cmd.Process.Pid
+  hlog.Infof("Stopping %s with %s (timeout %s, KillMode=%s)", name, SignalName(policy.Signal), policy.Timeout, policy.KillMode)
+  if err := signalStop(pid, policy); err != nil {
+    return "", err
+  }
+
+  var timeout <-chan time.Time
+  if policy.Timeout > 0 {
+    timeout = time.After(policy.Timeout)
+  }
+  select {
+  case e := <-exitedCh:
+    hlog.Infof("Process %s exited (%s)", name, e.StopResult)
+    if policy.KillMode != KillNone {
+      cleanupGroup(name, pid, policy, true)
+    }
+    return e.StopResult, nil
+  case <-timeout:
+  }
+
+  if !policy.SendSIGKILL || policy.KillMode == KillNone {
+    return "", fmt.Errorf("timeout waiting for %s to exit after %s", name, policy.Timeout)
+  }
 
-  // 发送 SIGKILL
-  if err := cmd.Process.Kill(); err != nil {
-    return err
+  hlog.Warnf("Process %s did not exit within %s; sending SIGKILL", name, policy.Timeout)
+  reg.setEscalated(name, true)
+  if err := signalKill(pid, policy); err != nil {
+    return "", err
   }
 
-  // 等待退出事件(最多 5 秒)
   select {
-  case <-exitedCh:
-    hlog.Infof("Process %s exited", name)
-    return nil
-  case <-time.After(5 * time.Second):
-    return fmt.Errorf("timeout waiting for %s to exit", name)
+  case e := <-exitedCh:
+    hlog.Infof("Process %s exited (%s)", name, e.StopResult)
+    return e.StopResult, nil
+  case <-time.After(killWait):
+    return "", fmt.Errorf("timeout waiting for %s to exit", name)
   }
 }
 
-func Status(name string) string {
-  if reg.isManualStop(name) {
-    return "stopped"
+// stopResult 判断手动停止时进程是正常退出还是被 SIGKILL 杀死
+func stopResult(name string, status ExitStatus) string {
+  if !reg.isEscalated(name) {
+    return StopGraceful
   }
-  cmd, ok := reg.getProc(name)
+  // 被接管的进程拿不到退出状态(Code=-1 且无信号), 升级后退出即视为被杀死
+  if status.Signal == syscall.SIGKILL || (status.Signal == 0 && status.Code < 0) {
+    return StopKilled
+  }
+  return StopGraceful
+}
+
+// Forget 在服务配置被删除并停止后清除它的记录, 不再出现在状态文件中
+func Forget(name string) {
+  reg.forget(name)
+  saveState()
+  logger.Close(name)
+}
+
+// UpdateLog 在 reload 时应用新的日志配置, 运行中的进程不需要重启
+func UpdateLog(name string, opts logger.Options) error {
+  spec, ok := reg.getMetadata(name)
   if !ok {
-    return "not found"
+    return fmt.Errorf("service %s not managed", name)
   }
-  if cmd.ProcessState != nil && cmd.ProcessState.Exited() {
-    return "exited"
+  spec.Log = opts
+  reg.setMetadata(name, spec)
+  return logger.Update(name, opts)
+}
+
+// Status 返回服务的状态名(见 State), 未知的服务返回 "not found"
+func Status(name string) string {
+  st, ok := reg.getState(name)
+  if !ok {
+    return "not found"
   }
-  return "running"
+  return string(st)
 }
 
 func List() map[string]string {
@@ -321,11 +790,7 @@ func Command(name string) string {
   if !ok {
     return ""
   }
-  const maxLen = 20
-  if len(cmd) <= maxLen {
-    return cmd
-  }
-  return cmd[:maxLen] + "…"
+  return truncate(cmd, 20)
 }
 
 func WorkingDir(name string) string {
@@ -333,19 +798,13 @@ func WorkingDir(name string) string {
   if !ok {
     return ""
   }
-  const maxLen = 20
-  if len(d) <= maxLen {
-    return d
-  }
-  return d[:maxLen] + "…"
+  return truncate(d, 20)
 }
 
-func shouldRestart(exitCode, retries int, policy RestartPolicy) bool {
-  if exitCode == 0 && !policy.RestartOnZero {
-    return false
-  }
-  if policy.MaxRetries >= 0 && retries >= policy.MaxRetries {
-    return false
+// truncate 按字符(而不是字节)截断, 不会切开多字节的 UTF-8 字符
+func truncate(s string, maxLen int) string {
+  if utf8.RuneCountInString(s) <= maxLen {
+    return s
   }
-  return true
+  return string([]rune(s)[:maxLen]) + "…"
 }
@@ -0,0 +1,173 @@
+package process
+
+import (
+  "fmt"
+  "os"
+  "path/filepath"
+  "strings"
+  "syscall"
+  "testing"
+  "time"
+
+  "github.com/litongjava/supers/internal/events"
+  "github.com/litongjava/supers/internal/logger"
+)
+
+// enableTestState 在临时目录中开启状态持久化和 FIFO 输出
+func enableTestState(t *testing.T) {
+  t.Helper()
+  if err := EnableState(filepath.Join(t.TempDir(), "state.json")); err != nil {
+    t.Fatal(err)
+  }
+  EnableOutputFIFO(true)
+  t.Cleanup(func() {
+    EnableOutputFIFO(false)
+    EnableState("")
+  })
+}
+
+// shellSpec 返回用 /bin/sh -c script 启动、不自动重启的 Spec, 日志写到临时目录
+func shellSpec(t *testing.T, script string) Spec {
+  return Spec{
+    Cmd:     []string{"/bin/sh", "-c", script},
+    Restart: RestartPolicy{Mode: RestartNo},
+    Stop:    DefaultStopPolicy(),
+    Log:     logger.Options{Dir: t.TempDir()},
+  }
+}
+
+// waitEvent 等待 ch 中的事件
+func waitEvent(t *testing.T, ch <-chan events.Event, what string) events.Event {
+  t.Helper()
+  select {
+  case e := <-ch:
+    return e
+  case <-time.After(5 * time.Second):
+    t.Fatalf("timed out waiting for %s", what)
+  }
+  return events.Event{}
+}
+
+func TestExitedLogTailHasLastLine(t *testing.T) {
+  enableTestState(t)
+  // 最后一行经 FIFO 或管道读出之前不能发出 process.exited, 多跑几次以覆盖时序
+  for i := 0; i < 40; i++ {
+    EnableOutputFIFO(i%2 == 0)
+    name := fmt.Sprintf("tail-%d", i)
+    exited := events.SubscribeOnce(name, events.EventProcessExited)
+    if _, err := Manage(name, shellSpec(t, "echo FATAL-CRASH >&2; exit 1")); err != nil {
+      t.Fatal(err)
+    }
+    e := waitEvent(t, exited, name+" to exit")
+    if len(e.LogTail) != 1 || e.LogTail[0] != "FATAL-CRASH" {
+      t.Fatalf("%s: LogTail = %q, want [FATAL-CRASH]", name, e.LogTail)
+    }
+    Forget(name)
+  }
+}
+
+// startGroup 启动一个带后台子进程的 sh, 等子进程启动后返回主进程 pid(也是进程组 id).
+// ignoreTerm 时 sh 和子进程都忽略 SIGTERM, 只能被 SIGKILL 杀死.
+func startGroup(t *testing.T, name string, ignoreTerm bool, stop StopPolicy) int {
+  t.Helper()
+  ready := filepath.Join(t.TempDir(), "ready")
+  trap := ":"
+  if ignoreTerm {
+    trap = `trap "" TERM`
+  }
+  spec := shellSpec(t, fmt.Sprintf("%s; sleep 60 & touch %s; wait", trap, ready))
+  spec.Stop = stop
+  pid, err := Manage(name, spec)
+  if err != nil {
+    t.Fatal(err)
+  }
+  t.Cleanup(func() {
+    signalGroup(pid, syscall.SIGKILL)
+    Forget(name)
+  })
+  deadline := time.Now().Add(5 * time.Second)
+  for {
+    if _, err := os.Stat(ready); err == nil {
+      return pid
+    }
+    if time.Now().After(deadline) {
+      t.Fatalf("%s did not start its child", name)
+    }
+    time.Sleep(10 * time.Millisecond)
+  }
+}
+
+func TestStop(t *testing.T) {
+  policy := func(mode KillMode, sendKill bool) StopPolicy {
+    return StopPolicy{Signal: syscall.SIGTERM, Timeout: 300 * time.Millisecond, SendSIGKILL: sendKill, KillMode: mode}
+  }
+  cases := []struct {
+    name       string
+    ignoreTerm bool
+    policy     StopPolicy
+    result     string // 为空表示 Stop 应返回错误
+    groupGone  bool   // Stop 返回后进程组是否已全部退出
+  }{
+    {"control-group graceful", false, policy(KillControlGroup, true), StopGraceful, true},
+    {"control-group killed", true, policy(KillControlGroup, true), StopKilled, true},
+    // KillSignal 只发给主进程, 主进程退出后残留的子进程被 SIGKILL
+    {"mixed graceful", false, policy(KillMixed, true), StopGraceful, true},
+    {"mixed killed", true, policy(KillMixed, true), StopKilled, true},
+    // 只处理主进程, 子进程保留
+    {"process graceful", false, policy(KillProcess, true), StopGraceful, false},
+    {"process killed", true, policy(KillProcess, true), StopKilled, false},
+    {"no SIGKILL", true, policy(KillControlGroup, false), "", false},
+    {"none", true, policy(KillNone, true), "", false},
+  }
+  for i, c := range cases {
+    t.Run(c.name, func(t *testing.T) {
+      name := fmt.Sprintf("stop-%d", i)
+      pid := startGroup(t, name, c.ignoreTerm, c.policy)
+      result, err := Stop(name)
+      if c.result == "" {
+        if err == nil || !strings.Contains(err.Error(), "timeout") {
+          t.Fatalf("Stop = %q, %v, want a timeout error", result, err)
+        }
+      } else if err != nil || result != c.result {
+        t.Fatalf("Stop = %q, %v, want %q", result, err, c.result)
+      }
+      // 主进程退出后子进程由 init 回收, 回收之前仍算在进程组中
+      if c.groupGone && !waitGroupGone(pid, 5*time.Second) {
+        t.Errorf("group %d is still alive", pid)
+      }
+      if !c.groupGone && !groupAlive(pid) {
+        t.Errorf("group %d is gone, want the children kept", pid)
+      }
+    })
+  }
+}
+
+func TestStopNotRunning(t *testing.T) {
+  name := "stop-not-running"
+  spec := shellSpec(t, "exit 1")
+  spec.Restart = RestartPolicy{Mode: RestartAlways, Delay: time.Minute}
+  exited := events.SubscribeOnce(name, events.EventProcessExited)
+  if _, err := Manage(name, spec); err != nil {
+    t.Fatal(err)
+  }
+  defer Forget(name)
+  waitEvent(t, exited, name+" to exit")
+  for deadline := time.Now().Add(5 * time.Second); Status(name) != string(StateBackoff); {
+    if time.Now().After(deadline) {
+      t.Fatalf("state = %s, want %s", Status(name), StateBackoff)
+    }
+    time.Sleep(10 * time.Millisecond)
+  }
+
+  // 正在等待重启的服务只记录停止标志
+  result, err := Stop(name)
+  if err != nil || result != StopNotRunning {
+    t.Fatalf("Stop = %q, %v, want %q", result, err, StopNotRunning)
+  }
+  if st := Status(name); st != string(StateInactive) {
+    t.Errorf("state = %s, want %s", st, StateInactive)
+  }
+  if _, err := Stop("no-such-service"); err == nil {
+    t.Error("Stop of an unknown service succeeded")
+  }
+}
@@ -0,0 +1,114 @@
+package process
+
+import (
+  "io"
+  "os"
+  "path/filepath"
+  "sync"
+  "syscall"
+  "time"
+
+  "github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+// 开启 EnableOutputFIFO 后, 子进程的 stdout/stderr 接到 <stateDir>/fifo/<name>.<stream> 上.
+// 子进程以 O_RDWR 打开 FIFO, 自己也持有读端, superd 退出时不会因为 SIGPIPE 被杀死;
+// 写满内核缓冲区后阻塞, 直到新的 superd 重新打开 FIFO 继续读取.
+// 没有开启时子进程经管道输出, superd 退出后再写输出会收到 SIGPIPE.
+// 管道也由这里创建而不是交给 exec: exec 的 Wait 要等管道读到 EOF, KillMode=process 时
+// 留下的子进程仍持有输出, 主进程退出后 Wait 不会返回.
+
+// outputDrainTimeout 是进程退出后等待剩余输出写入日志的最长时间;
+// 进程 fork 出的子进程仍持有输出时不会读到 EOF, 不再继续等待
+const outputDrainTimeout = time.Second
+
+func fifoPath(name, stream string) string {
+  return filepath.Join(stateDir(), "fifo", name+"."+stream)
+}
+
+// attachOutput 为子进程准备一个输出流, 返回交给子进程的文件; 调用方在 Start 后关闭它.
+// 开启 FIFO 时返回 FIFO, 否则返回管道; w 为 nil 时返回 nil. 复制输出的 goroutine 记在 copies 中.
+func attachOutput(name, stream string, w io.Writer, copies *sync.WaitGroup) (*os.File, error) {
+  if w == nil {
+    return nil, nil
+  }
+  if !fifoEnabled() {
+    if stateDir() != "" {
+      // 删除之前留下的 FIFO, 以后接管这个进程时不会误读
+      os.Remove(fifoPath(name, stream))
+    }
+    r, child, err := os.Pipe()
+    if err != nil {
+      return nil, err
+    }
+    copies.Add(1)
+    go copyOutput(name, stream, r, w, copies)
+    return child, nil
+  }
+  path := fifoPath(name, stream)
+  if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+    return nil, err
+  }
+  os.Remove(path)
+  if err := syscall.Mkfifo(path, 0o600); err != nil {
+    return nil, err
+  }
+  child, err := os.OpenFile(path, os.O_RDWR, 0)
+  if err != nil {
+    return nil, err
+  }
+  // 已有写端, 以只读方式打开不会阻塞
+  r, err := os.OpenFile(path, os.O_RDONLY, 0)
+  if err != nil {
+    child.Close()
+    return nil, err
+  }
+  copies.Add(1)
+  go copyOutput(name, stream, r, w, copies)
+  return child, nil
+}
+
+// reattachOutput 在接管旧进程时重新读取它的 FIFO; 旧进程经管道输出时没有 FIFO.
+// 即使现在关闭了 FIFO 也要读取, 否则旧进程写满缓冲区后会阻塞.
+func reattachOutput(name, stream string, w io.Writer, copies *sync.WaitGroup) {
+  if stateDir() == "" || w == nil {
+    return
+  }
+  r, err := os.OpenFile(fifoPath(name, stream), os.O_RDONLY|syscall.O_NONBLOCK, 0)
+  if os.IsNotExist(err) {
+    return
+  }
+  if err != nil {
+    hlog.Warnf("%s: cannot reattach %s: %v", name, stream, err)
+    return
+  }
+  copies.Add(1)
+  go copyOutput(name, stream, r, w, copies)
+}
+
+func copyOutput(name, stream string, r *os.File, w io.Writer, copies *sync.WaitGroup) {
+  defer copies.Done()
+  defer r.Close()
+  if _, err := io.Copy(w, r); err != nil {
+    hlog.Warnf("%s: copy %s failed: %v", name, stream, err)
+  }
+}
+
+// waitOutput 等待已退出进程的输出读完, 最多 outputDrainTimeout.
+// Wait 返回后可能还有数据没有读出, 在此之前 logger.Tail 会缺少最后几行.
+func waitOutput(name string) {
+  copies := reg.getOutput(name)
+  if copies == nil {
+    return
+  }
+  done := make(chan struct{})
+  go func() {
+    copies.Wait()
+    close(done)
+  }()
+  select {
+  case <-done:
+  case <-time.After(outputDrainTimeout):
+    hlog.Warnf("%s: output is still open after the process exited", name)
+  }
+}
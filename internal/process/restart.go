@@ -0,0 +1,201 @@
+package process
+
+import (
+  "fmt"
+  "math"
+  "math/rand"
+  "os"
+  "strconv"
+  "strings"
+  "syscall"
+  "time"
+)
+
+// RestartMode 对应 unit 文件中的 Restart=
+type RestartMode string
+
+const (
+  RestartNo         RestartMode = "no"
+  RestartAlways     RestartMode = "always"
+  RestartOnSuccess  RestartMode = "on-success"
+  RestartOnFailure  RestartMode = "on-failure"
+  RestartOnAbnormal RestartMode = "on-abnormal"
+  RestartOnAbort    RestartMode = "on-abort"
+  RestartOnWatchdog RestartMode = "on-watchdog" // superd 没有 watchdog, 等同于 no
+)
+
+// ParseRestartMode validates a Restart= value.
+func ParseRestartMode(s string) (RestartMode, error) {
+  switch m := RestartMode(strings.TrimSpace(s)); m {
+  case RestartNo, RestartAlways, RestartOnSuccess, RestartOnFailure,
+    RestartOnAbnormal, RestartOnAbort, RestartOnWatchdog:
+    return m, nil
+  }
+  return "", fmt.Errorf("unknown Restart %q", s)
+}
+
+// ExitStatus 描述进程的退出方式; Signal 非 0 表示被信号终止
+type ExitStatus struct {
+  Code     int
+  Signal   syscall.Signal
+  CoreDump bool // 被信号终止并生成了 core dump
+}
+
+func exitStatusOf(state *os.ProcessState) ExitStatus {
+  if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+    return ExitStatus{Code: -1, Signal: ws.Signal(), CoreDump: ws.CoreDump()}
+  }
+  return ExitStatus{Code: state.ExitCode()}
+}
+
+func (s ExitStatus) String() string {
+  if s.Signal != 0 {
+    return "signal " + SignalName(s.Signal)
+  }
+  return "code " + strconv.Itoa(s.Code)
+}
+
+// ExitStatusSet 是 SuccessExitStatus= 等配置项的值: 退出码和信号的集合
+type ExitStatusSet struct {
+  Codes   []int
+  Signals []syscall.Signal
+}
+
+// ParseExitStatusSet 解析空格分隔的退出码与信号名, 如 "1 6 SIGABRT"
+func ParseExitStatusSet(s string) (ExitStatusSet, error) {
+  var set ExitStatusSet
+  for _, f := range strings.Fields(s) {
+    if n, err := strconv.Atoi(f); err == nil {
+      if n < 0 || n > 255 {
+        return set, fmt.Errorf("exit status %d out of range", n)
+      }
+      set.Codes = append(set.Codes, n)
+      continue
+    }
+    sig, err := ParseSignal(f)
+    if err != nil {
+      return set, err
+    }
+    set.Signals = append(set.Signals, sig)
+  }
+  return set, nil
+}
+
+// Merge appends other to the set.
+func (set ExitStatusSet) Merge(other ExitStatusSet) ExitStatusSet {
+  set.Codes = append(set.Codes, other.Codes...)
+  set.Signals = append(set.Signals, other.Signals...)
+  return set
+}
+
+func (set ExitStatusSet) Contains(s ExitStatus) bool {
+  if s.Signal != 0 {
+    for _, sig := range set.Signals {
+      if sig == s.Signal {
+        return true
+      }
+    }
+    return false
+  }
+  for _, c := range set.Codes {
+    if c == s.Code {
+      return true
+    }
+  }
+  return false
+}
+
+// clean 判断退出是否视为成功: 退出码 0、SIGHUP/SIGINT/SIGTERM/SIGPIPE 或 SuccessExitStatus= 中的值
+func (p RestartPolicy) clean(s ExitStatus) bool {
+  if p.SuccessExitStatus.Contains(s) {
+    return true
+  }
+  switch s.Signal {
+  case 0:
+    return s.Code == 0
+  case syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGPIPE:
+    return true
+  }
+  return false
+}
+
+// shouldRestart 按 systemd 的 Restart= 语义判断是否需要重启
+func shouldRestart(status ExitStatus, policy RestartPolicy) bool {
+  if policy.PreventExitStatus.Contains(status) {
+    return false
+  }
+  if policy.ForceExitStatus.Contains(status) {
+    return true
+  }
+
+  clean := policy.clean(status)
+  switch policy.Mode {
+  case RestartAlways:
+    return true
+  case RestartOnSuccess:
+    return clean
+  case RestartOnFailure:
+    return !clean
+  case RestartOnAbnormal, RestartOnAbort:
+    // 只有被未捕获的信号终止才重启(superd 没有启动/看门狗超时)
+    return !clean && status.Signal != 0
+  }
+  return false
+}
+
+// backoff 返回第 n 次(从 0 开始)连续重启前的等待时间.
+// 配置了 RestartSteps= 时按 systemd 的方式在 Steps 步内从 Delay 指数增长到 MaxDelay,
+// 否则每次翻倍直到 MaxDelay.
+func (p RestartPolicy) backoff(n int) time.Duration {
+  d := p.Delay
+  if p.MaxDelay > p.Delay && n > 0 {
+    base := p.Delay
+    if base <= 0 {
+      base = 100 * time.Millisecond
+    }
+    if p.Steps > 0 {
+      if n >= p.Steps {
+        d = p.MaxDelay
+      } else {
+        ratio := float64(p.MaxDelay) / float64(base)
+        d = time.Duration(float64(base) * math.Pow(ratio, float64(n)/float64(p.Steps)))
+      }
+    } else {
+      d = base
+      for i := 0; i < n && d < p.MaxDelay; i++ {
+        d *= 2
+      }
+    }
+    if d > p.MaxDelay {
+      d = p.MaxDelay
+    }
+  }
+  if p.Jitter > 0 {
+    d += time.Duration(rand.Int63n(int64(p.Jitter)))
+  }
+  return d
+}
+
+// DefaultStartLimitInterval 是没有配置 StartLimitIntervalSec= 时窗口的基础长度
+const DefaultStartLimitInterval = 10 * time.Second
+
+// DefaultStartLimit 返回没有配置 StartLimitIntervalSec= 时使用的窗口:
+// DefaultStartLimitInterval 加上连续 Burst 次启动之间的退避时间.
+// 否则 RestartSec= 较长时 Burst 次启动不可能落在窗口内, 崩溃循环永远不会被放弃.
+func (p RestartPolicy) DefaultStartLimit() time.Duration {
+  d := DefaultStartLimitInterval
+  q := p
+  q.Jitter = 0
+  for n := 0; n < p.StartLimitBurst-1; n++ {
+    d += q.backoff(n) + p.Jitter
+  }
+  return d
+}
+
+// stableAfter 是进程需要连续运行多久才重置退避计数
+func (p RestartPolicy) stableAfter() time.Duration {
+  if p.MaxDelay > p.StartLimitInterval {
+    return p.MaxDelay
+  }
+  return p.StartLimitInterval
+}
@@ -0,0 +1,215 @@
+package process
+
+import (
+  "fmt"
+  "syscall"
+  "testing"
+  "time"
+
+  "github.com/litongjava/supers/internal/events"
+)
+
+func TestShouldRestart(t *testing.T) {
+  var (
+    ok      = ExitStatus{Code: 0}
+    fail    = ExitStatus{Code: 1}
+    term    = ExitStatus{Code: -1, Signal: syscall.SIGTERM}
+    killed  = ExitStatus{Code: -1, Signal: syscall.SIGKILL}
+    aborted = ExitStatus{Code: -1, Signal: syscall.SIGABRT, CoreDump: true}
+  )
+  statuses := []ExitStatus{ok, fail, term, killed, aborted}
+  // 每行依次对应 statuses 中的 ok/fail/term/killed/aborted
+  cases := []struct {
+    mode    RestartMode
+    success ExitStatusSet
+    want    [5]bool
+  }{
+    {RestartNo, ExitStatusSet{}, [5]bool{false, false, false, false, false}},
+    {RestartAlways, ExitStatusSet{}, [5]bool{true, true, true, true, true}},
+    {RestartOnSuccess, ExitStatusSet{}, [5]bool{true, false, true, false, false}},
+    {RestartOnFailure, ExitStatusSet{}, [5]bool{false, true, false, true, true}},
+    {RestartOnAbnormal, ExitStatusSet{}, [5]bool{false, false, false, true, true}},
+    {RestartOnAbort, ExitStatusSet{}, [5]bool{false, false, false, true, true}},
+    {RestartOnWatchdog, ExitStatusSet{}, [5]bool{false, false, false, false, false}},
+    // SuccessExitStatus= 中的退出码和信号按成功处理
+    {RestartOnSuccess, ExitStatusSet{Codes: []int{1}}, [5]bool{true, true, true, false, false}},
+    {RestartOnFailure, ExitStatusSet{Codes: []int{1}}, [5]bool{false, false, false, true, true}},
+    {RestartOnFailure, ExitStatusSet{Signals: []syscall.Signal{syscall.SIGKILL}}, [5]bool{false, true, false, false, true}},
+    {RestartOnAbnormal, ExitStatusSet{Signals: []syscall.Signal{syscall.SIGABRT}}, [5]bool{false, false, false, true, false}},
+  }
+  for _, c := range cases {
+    policy := RestartPolicy{Mode: c.mode, SuccessExitStatus: c.success}
+    for i, s := range statuses {
+      if got := shouldRestart(s, policy); got != c.want[i] {
+        t.Errorf("Restart=%s SuccessExitStatus=%v, exit %s: shouldRestart = %v, want %v", c.mode, c.success, s, got, c.want[i])
+      }
+    }
+  }
+}
+
+func TestShouldRestartPreventAndForce(t *testing.T) {
+  cases := []struct {
+    name   string
+    policy RestartPolicy
+    status ExitStatus
+    want   bool
+  }{
+    {"prevent overrides always", RestartPolicy{Mode: RestartAlways, PreventExitStatus: ExitStatusSet{Codes: []int{3}}}, ExitStatus{Code: 3}, false},
+    {"prevent by signal", RestartPolicy{Mode: RestartAlways, PreventExitStatus: ExitStatusSet{Signals: []syscall.Signal{syscall.SIGKILL}}}, ExitStatus{Code: -1, Signal: syscall.SIGKILL}, false},
+    {"prevent does not match other codes", RestartPolicy{Mode: RestartAlways, PreventExitStatus: ExitStatusSet{Codes: []int{3}}}, ExitStatus{Code: 4}, true},
+    {"force overrides no", RestartPolicy{Mode: RestartNo, ForceExitStatus: ExitStatusSet{Codes: []int{0}}}, ExitStatus{Code: 0}, true},
+    {"force overrides on-failure for a clean exit", RestartPolicy{Mode: RestartOnFailure, ForceExitStatus: ExitStatusSet{Signals: []syscall.Signal{syscall.SIGTERM}}}, ExitStatus{Code: -1, Signal: syscall.SIGTERM}, true},
+    {"prevent wins over force", RestartPolicy{Mode: RestartAlways, PreventExitStatus: ExitStatusSet{Codes: []int{2}}, ForceExitStatus: ExitStatusSet{Codes: []int{2}}}, ExitStatus{Code: 2}, false},
+  }
+  for _, c := range cases {
+    if got := shouldRestart(c.status, c.policy); got != c.want {
+      t.Errorf("%s: shouldRestart = %v, want %v", c.name, got, c.want)
+    }
+  }
+}
+
+func TestBackoff(t *testing.T) {
+  cases := []struct {
+    name   string
+    policy RestartPolicy
+    want   []time.Duration // 第 0、1、2... 次重启前的等待时间
+  }{
+    {"fixed delay", RestartPolicy{Delay: time.Second},
+      []time.Duration{time.Second, time.Second, time.Second}},
+    {"max delay not above delay", RestartPolicy{Delay: 2 * time.Second, MaxDelay: time.Second},
+      []time.Duration{2 * time.Second, 2 * time.Second}},
+    {"doubling capped at max delay", RestartPolicy{Delay: time.Second, MaxDelay: 10 * time.Second},
+      []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}},
+    {"zero delay starts at 100ms", RestartPolicy{MaxDelay: time.Second},
+      []time.Duration{0, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second}},
+    {"steps", RestartPolicy{Delay: time.Second, MaxDelay: 16 * time.Second, Steps: 4},
+      []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 16 * time.Second}},
+  }
+  for _, c := range cases {
+    for n, want := range c.want {
+      got := c.policy.backoff(n)
+      // RestartSteps= 用浮点计算, 允许 1ms 误差
+      if diff := got - want; diff > time.Millisecond || diff < -time.Millisecond {
+        t.Errorf("%s: backoff(%d) = %s, want %s", c.name, n, got, want)
+      }
+    }
+  }
+
+  // 抖动叠加在退避时间上, 范围为 [d, d+Jitter)
+  p := RestartPolicy{Delay: time.Second, MaxDelay: 4 * time.Second, Jitter: 500 * time.Millisecond}
+  for i := 0; i < 100; i++ {
+    if got := p.backoff(5); got < 4*time.Second || got >= 4*time.Second+p.Jitter {
+      t.Fatalf("backoff with jitter = %s, want within [4s, 4.5s)", got)
+    }
+  }
+}
+
+func TestDefaultStartLimit(t *testing.T) {
+  cases := []struct {
+    policy RestartPolicy
+    want   time.Duration
+  }{
+    {RestartPolicy{Delay: 100 * time.Millisecond, StartLimitBurst: 5}, 10*time.Second + 400*time.Millisecond},
+    {RestartPolicy{Delay: time.Minute, StartLimitBurst: 3}, 10*time.Second + 2*time.Minute},
+    {RestartPolicy{Delay: time.Second, MaxDelay: 4 * time.Second, StartLimitBurst: 5}, 10*time.Second + (1+2+4+4)*time.Second},
+    {RestartPolicy{Delay: time.Second, Jitter: time.Second, StartLimitBurst: 3}, 10*time.Second + 4*time.Second},
+    {RestartPolicy{Delay: time.Second, StartLimitBurst: 0}, 10 * time.Second},
+  }
+  for _, c := range cases {
+    if got := c.policy.DefaultStartLimit(); got != c.want {
+      t.Errorf("DefaultStartLimit(%+v) = %s, want %s", c.policy, got, c.want)
+    }
+  }
+}
+
+func TestStartLimitHit(t *testing.T) {
+  now := time.Now()
+  cases := []struct {
+    name     string
+    starts   []time.Duration // 相对 now 的启动时间
+    interval time.Duration
+    burst    int
+    want     bool
+  }{
+    {"below burst", []time.Duration{-3 * time.Second, -time.Second}, 10 * time.Second, 3, false},
+    {"burst reached", []time.Duration{-3 * time.Second, -2 * time.Second, -time.Second}, 10 * time.Second, 3, true},
+    {"old starts outside the interval", []time.Duration{-30 * time.Second, -20 * time.Second, -time.Second}, 10 * time.Second, 3, false},
+    {"no interval", []time.Duration{-3 * time.Second, -2 * time.Second, -time.Second}, 0, 3, false},
+    {"no burst", []time.Duration{-time.Second}, 10 * time.Second, 0, false},
+  }
+  for _, c := range cases {
+    r := newRegistry()
+    for _, d := range c.starts {
+      r.recordStart("svc", now.Add(d), c.interval)
+    }
+    if got := r.startLimitHit("svc", now, c.interval, c.burst); got != c.want {
+      t.Errorf("%s: startLimitHit = %v, want %v", c.name, got, c.want)
+    }
+  }
+
+  // 手动启动清除启动记录, 自动重启的稳定重置只清除退避计数
+  r := newRegistry()
+  for i := 0; i < 3; i++ {
+    r.recordStart("svc", now, time.Minute)
+    r.nextRestart("svc")
+  }
+  r.resetRestarts("svc", false)
+  if r.getRestarts("svc") != 0 || !r.startLimitHit("svc", now, time.Minute, 3) {
+    t.Errorf("resetRestarts(false): restarts = %d, start limit hit = %v", r.getRestarts("svc"), r.startLimitHit("svc", now, time.Minute, 3))
+  }
+  r.resetRestarts("svc", true)
+  if r.startLimitHit("svc", now, time.Minute, 1) {
+    t.Error("resetRestarts(true) kept the start records")
+  }
+}
+
+func TestStableAfter(t *testing.T) {
+  cases := []struct {
+    policy RestartPolicy
+    want   time.Duration
+  }{
+    {RestartPolicy{StartLimitInterval: 10 * time.Second}, 10 * time.Second},
+    {RestartPolicy{MaxDelay: time.Minute, StartLimitInterval: 10 * time.Second}, time.Minute},
+    {RestartPolicy{}, 0},
+  }
+  for _, c := range cases {
+    if got := c.policy.stableAfter(); got != c.want {
+      t.Errorf("stableAfter(%+v) = %s, want %s", c.policy, got, c.want)
+    }
+  }
+}
+
+func TestStableRunResetsBackoff(t *testing.T) {
+  // 进程每次运行约 300ms; stableAfter 小于运行时间时每次退出都从 RestartSec 重新退避
+  cases := []struct {
+    name     string
+    maxDelay time.Duration
+    want     []int // 连续三次 process.restarted 的 Restarts
+  }{
+    {"stable", 200 * time.Millisecond, []int{1, 1, 1}},
+    {"not stable", 5 * time.Second, []int{1, 2, 3}},
+  }
+  for i, c := range cases {
+    name := fmt.Sprintf("stable-%d", i)
+    spec := shellSpec(t, "sleep 0.3; exit 1")
+    spec.Restart = RestartPolicy{Mode: RestartAlways, Delay: 10 * time.Millisecond, MaxDelay: c.maxDelay}
+
+    restarted := events.SubscribeOnce(name, events.EventProcessRestarted)
+    if _, err := Manage(name, spec); err != nil {
+      t.Fatal(err)
+    }
+    var got []int
+    for len(got) < len(c.want) {
+      e := waitEvent(t, restarted, name+" to restart")
+      restarted = events.SubscribeOnce(name, events.EventProcessRestarted)
+      got = append(got, e.Restarts)
+    }
+    if _, err := Stop(name); err != nil {
+      t.Error(err)
+    }
+    Forget(name)
+    if fmt.Sprint(got) != fmt.Sprint(c.want) {
+      t.Errorf("%s: Restarts = %v, want %v", c.name, got, c.want)
+    }
+  }
+}
@@ -0,0 +1,64 @@
+package process
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+  "syscall"
+)
+
+var signalNames = map[string]syscall.Signal{
+  "SIGHUP":   syscall.SIGHUP,
+  "SIGINT":   syscall.SIGINT,
+  "SIGQUIT":  syscall.SIGQUIT,
+  "SIGKILL":  syscall.SIGKILL,
+  "SIGUSR1":  syscall.SIGUSR1,
+  "SIGUSR2":  syscall.SIGUSR2,
+  "SIGTERM":  syscall.SIGTERM,
+  "SIGCONT":  syscall.SIGCONT,
+  "SIGSTOP":  syscall.SIGSTOP,
+  "SIGABRT":  syscall.SIGABRT,
+  "SIGWINCH": syscall.SIGWINCH,
+  "SIGPIPE":  syscall.SIGPIPE,
+  "SIGALRM":  syscall.SIGALRM,
+  // 进程异常终止时常见的信号, 用于在事件和状态中显示信号名
+  "SIGSEGV": syscall.SIGSEGV,
+  "SIGBUS":  syscall.SIGBUS,
+  "SIGFPE":  syscall.SIGFPE,
+  "SIGILL":  syscall.SIGILL,
+  "SIGTRAP": syscall.SIGTRAP,
+  "SIGSYS":  syscall.SIGSYS,
+  "SIGXCPU": syscall.SIGXCPU,
+  "SIGXFSZ": syscall.SIGXFSZ,
+}
+
+// maxSignal 是 Linux 上最大的信号编号(SIGRTMAX), 更大的编号 kill(2) 会返回 EINVAL
+const maxSignal = 64
+
+// ParseSignal 解析 KillSignal= 的值, 支持 SIGTERM / TERM / 15 三种写法
+func ParseSignal(s string) (syscall.Signal, error) {
+  s = strings.ToUpper(strings.TrimSpace(s))
+  if n, err := strconv.Atoi(s); err == nil {
+    if n < 1 || n > maxSignal {
+      return 0, fmt.Errorf("signal %d out of range 1-%d", n, maxSignal)
+    }
+    return syscall.Signal(n), nil
+  }
+  if !strings.HasPrefix(s, "SIG") {
+    s = "SIG" + s
+  }
+  if sig, ok := signalNames[s]; ok {
+    return sig, nil
+  }
+  return 0, fmt.Errorf("unknown signal %q", s)
+}
+
+// SignalName returns the SIGxxx name of sig, or its number if unknown.
+func SignalName(sig syscall.Signal) string {
+  for name, s := range signalNames {
+    if s == sig {
+      return name
+    }
+  }
+  return strconv.Itoa(int(sig))
+}
@@ -0,0 +1,39 @@
+package process
+
+import (
+  "syscall"
+  "testing"
+)
+
+func TestParseSignal(t *testing.T) {
+  cases := []struct {
+    in   string
+    want syscall.Signal
+    err  bool
+  }{
+    {in: "SIGTERM", want: syscall.SIGTERM},
+    {in: "term", want: syscall.SIGTERM},
+    {in: " SIGKILL ", want: syscall.SIGKILL},
+    {in: "15", want: syscall.SIGTERM},
+    {in: "1", want: syscall.SIGHUP},
+    {in: "64", want: syscall.Signal(64)},
+    {in: "0", err: true},
+    {in: "-9", err: true},
+    {in: "65", err: true},
+    {in: "999", err: true},
+    {in: "SIGFOO", err: true},
+    {in: "", err: true},
+  }
+  for _, c := range cases {
+    got, err := ParseSignal(c.in)
+    if c.err {
+      if err == nil {
+        t.Errorf("ParseSignal(%q) = %v, want an error", c.in, got)
+      }
+      continue
+    }
+    if err != nil || got != c.want {
+      t.Errorf("ParseSignal(%q) = %v, %v, want %v", c.in, got, err, c.want)
+    }
+  }
+}
@@ -0,0 +1,249 @@
+package process
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "os/exec"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/cloudwego/hertz/pkg/common/hlog"
+  "github.com/litongjava/supers/internal/events"
+  "github.com/litongjava/supers/internal/logger"
+)
+
+// savedProc 是写入状态文件的单个服务记录
+type savedProc struct {
+  Name       string    `json:"name"`
+  PID        int       `json:"pid,omitempty"`
+  StartTime  time.Time `json:"start_time,omitempty"`
+  ProcStart  uint64    `json:"proc_start,omitempty"` // /proc/<pid>/stat 第 22 列, 用于识别 PID 复用
+  Cmdline    []string  `json:"cmdline,omitempty"`
+  ManualStop bool      `json:"manual_stop,omitempty"`
+}
+
+var (
+  stateMu    sync.Mutex
+  stateFile  string
+  outputFIFO bool
+  savedProcs = make(map[string]savedProc)
+
+  // saveMu 串行化 saveState, 避免并发停止时较旧的快照覆盖较新的
+  saveMu sync.Mutex
+)
+
+// RestoreResult 描述 Restore 对一个服务做了什么
+type RestoreResult int
+
+const (
+  RestoreNone    RestoreResult = iota // 没有可接管的进程, 需要正常启动
+  RestoreAdopted                      // 已接管仍在运行的旧进程
+  RestoreStopped                      // 上次被手动停止, 保持停止状态
+)
+
+// EnableState 开启状态持久化并读取上次保存的状态; path 为空时关闭持久化
+func EnableState(path string) error {
+  stateMu.Lock()
+  defer stateMu.Unlock()
+  stateFile = path
+  if path == "" {
+    return nil
+  }
+  if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+    return err
+  }
+  data, err := ioutil.ReadFile(path)
+  if os.IsNotExist(err) {
+    return nil
+  }
+  if err != nil {
+    return err
+  }
+  var list []savedProc
+  if err := json.Unmarshal(data, &list); err != nil {
+    return fmt.Errorf("parse %s: %w", path, err)
+  }
+  for _, p := range list {
+    savedProcs[p.Name] = p
+  }
+  return nil
+}
+
+// EnableOutputFIFO 决定之后启动的进程是否经 FIFO 输出(见 attachOutput); 只在开启状态持久化时生效
+func EnableOutputFIFO(on bool) {
+  stateMu.Lock()
+  outputFIFO = on
+  stateMu.Unlock()
+}
+
+func fifoEnabled() bool {
+  stateMu.Lock()
+  defer stateMu.Unlock()
+  return outputFIFO && stateFile != ""
+}
+
+func stateDir() string {
+  stateMu.Lock()
+  defer stateMu.Unlock()
+  if stateFile == "" {
+    return ""
+  }
+  return filepath.Dir(stateFile)
+}
+
+// SavedNames 返回状态文件中记录的服务名
+func SavedNames() []string {
+  stateMu.Lock()
+  defer stateMu.Unlock()
+  names := make([]string, 0, len(savedProcs))
+  for n := range savedProcs {
+    names = append(names, n)
+  }
+  return names
+}
+
+// Restore 尝试接管上一个 superd 留下的进程.
+// 只有 PID 的 /proc 启动时间和命令行都与记录一致时才接管, 否则视为 PID 已被复用.
+func Restore(name string, spec Spec) RestoreResult {
+  stateMu.Lock()
+  saved, ok := savedProcs[name]
+  delete(savedProcs, name)
+  stateMu.Unlock()
+  if !ok {
+    return RestoreNone
+  }
+
+  if saved.PID == 0 || len(saved.Cmdline) == 0 || !sameProcess(saved) {
+    if saved.ManualStop {
+      reg.setMetadata(name, spec)
+      reg.setManualStop(name, true)
+      setState(name, StateInactive)
+      return RestoreStopped
+    }
+    return RestoreNone
+  }
+
+  p, err := os.FindProcess(saved.PID)
+  if err != nil {
+    return RestoreNone
+  }
+  c := &exec.Cmd{Path: saved.Cmdline[0], Args: saved.Cmdline, Process: p}
+
+  reg.setMetadata(name, spec)
+  reg.setManualStop(name, false)
+  reg.setEscalated(name, false)
+  reg.setStartTime(name, saved.StartTime)
+  reg.setCommand(name, strings.Join(saved.Cmdline, " "))
+  reg.setProcInfo(name, saved.ProcStart, saved.Cmdline)
+  reg.setExited(name, false)
+  reg.setProc(name, c)
+  setState(name, StateRunning)
+
+  stdoutW, stderrW, err := logger.SetupLog(name, spec.Log)
+  if err != nil {
+    hlog.Errorf("logger setup failed for %s: %v", name, err)
+  }
+  copies := &sync.WaitGroup{}
+  reattachOutput(name, "stdout", stdoutW, copies)
+  reattachOutput(name, "stderr", stderrW, copies)
+  reg.setOutput(name, copies)
+  logger.SetPID(name, saved.PID)
+
+  hlog.Infof("Adopted %s PID=%d (started %s)", name, saved.PID, saved.StartTime.Format(time.RFC3339))
+  events.Emit(events.Event{Name: name, Type: events.EventProcessAdopted, PID: saved.PID})
+  go watchAdopted(name, c, saved.ProcStart)
+  return RestoreAdopted
+}
+
+// watchAdopted 轮询被接管的进程(不是 superd 的子进程, 无法 Wait), 退出后按正常流程处理
+func watchAdopted(name string, c *exec.Cmd, procStart uint64) {
+  for {
+    time.Sleep(time.Second)
+    if st, err := readProcStart(c.Process.Pid); err != nil || st != procStart {
+      break
+    }
+  }
+  // 退出码无从得知, 按异常退出处理
+  handleExit(name, c, ExitStatus{Code: -1}, nil)
+}
+
+func sameProcess(saved savedProc) bool {
+  st, err := readProcStart(saved.PID)
+  if err != nil || st != saved.ProcStart {
+    return false
+  }
+  cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", saved.PID))
+  if err != nil {
+    return false
+  }
+  args := strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+  if len(args) != len(saved.Cmdline) {
+    return false
+  }
+  for i := range args {
+    if args[i] != saved.Cmdline[i] {
+      return false
+    }
+  }
+  return true
+}
+
+// readProcStart 读取进程启动时间(自开机以来的 clock ticks)
+func readProcStart(pid int) (uint64, error) {
+  data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+  if err != nil {
+    return 0, err
+  }
+  // comm 可能包含空格和括号, 从最后一个 ')' 之后开始切分; starttime 是第 22 列
+  i := bytes.LastIndexByte(data, ')')
+  if i < 0 {
+    return 0, fmt.Errorf("malformed /proc/%d/stat", pid)
+  }
+  fields := strings.Fields(string(data[i+1:]))
+  if len(fields) < 20 {
+    return 0, fmt.Errorf("malformed /proc/%d/stat", pid)
+  }
+  return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// saveState 把当前运行中和被手动停止的服务写入状态文件
+func saveState() {
+  saveMu.Lock()
+  defer saveMu.Unlock()
+  stateMu.Lock()
+  path := stateFile
+  stateMu.Unlock()
+  if path == "" {
+    return
+  }
+
+  list := reg.snapshotState()
+  // 尚未被 Restore 处理的旧记录继续保留
+  stateMu.Lock()
+  for _, p := range savedProcs {
+    list = append(list, p)
+  }
+  stateMu.Unlock()
+
+  data, err := json.MarshalIndent(list, "", "  ")
+  if err != nil {
+    hlog.Errorf("marshal state failed: %v", err)
+    return
+  }
+  stateMu.Lock()
+  defer stateMu.Unlock()
+  tmp := path + ".tmp"
+  if err := ioutil.WriteFile(tmp, data, 0o600); err != nil {
+    hlog.Errorf("write state %s failed: %v", tmp, err)
+    return
+  }
+  if err := os.Rename(tmp, path); err != nil {
+    hlog.Errorf("rename state %s failed: %v", path, err)
+  }
+}
@@ -0,0 +1,42 @@
+package process
+
+import (
+  "time"
+
+  "github.com/litongjava/supers/internal/protocol"
+)
+
+// Describe returns the full status of a service, with the untruncated command line.
+func Describe(name string) protocol.ServiceStatus {
+  st := protocol.ServiceStatus{
+    Name:           name,
+    State:          Status(name),
+    Restarts:       reg.getRestarts(name),
+    LastStartError: reg.getStartError(name),
+    Transitions:    reg.getTransitions(name),
+  }
+  if n := len(st.Transitions); n > 0 {
+    st.StateSince = &st.Transitions[n-1].At
+  }
+  if c, ok := reg.getProc(name); ok && !reg.isExited(name) && c.Process != nil {
+    st.PID = c.Process.Pid
+    if start, ok := reg.getStartTime(name); ok {
+      st.StartedAt = &start
+      st.UptimeSec = int64(time.Since(start) / time.Second)
+    }
+  }
+  if s, ok := reg.getLastExit(name); ok {
+    st.LastExit = &protocol.LastExit{Code: s.Code}
+    if s.Signal != 0 {
+      st.LastExit.Signal = SignalName(s.Signal)
+    }
+  }
+  st.Command = reg.getArgv(name)
+  if spec, ok := reg.getMetadata(name); ok {
+    if len(st.Command) == 0 {
+      st.Command = spec.Cmd
+    }
+    st.WorkingDirectory = spec.WorkingDirectory
+  }
+  return st
+}
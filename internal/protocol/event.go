@@ -0,0 +1,38 @@
+package protocol
+
+import "time"
+
+// EventType represents a type of event emitted by superd.
+type EventType string
+
+const (
+	EventProcessStarted      EventType = "process.started"
+	EventProcessStartFailed  EventType = "process.start_failed"
+	EventProcessExited       EventType = "process.exited"
+	EventProcessRestarted    EventType = "process.restarted" // 自动重启的进程启动成功后发出
+	EventProcessGaveUp       EventType = "process.gave_up"   // 达到 StartLimitBurst, 不再自动重启
+	EventProcessAdopted      EventType = "process.adopted"   // superd 重启后接管了仍在运行的旧进程
+	EventProcessStateChanged EventType = "process.state_changed"
+)
+
+// Event carries information about a process event.
+// 它是 webhook、events.exec 和 events 命令输出的 JSON; superd 内部通过 internal/events 分发.
+type Event struct {
+	ID         string    `json:"id"`
+	Seq        uint64    `json:"seq"` // superd 启动后单调递增, 用于排序和发现丢失的事件
+	Time       time.Time `json:"time"`
+	Host       string    `json:"host"`
+	Name       string    `json:"name"`
+	Type       EventType `json:"type"`
+	ExitCode   int       `json:"exit_code,omitempty"`
+	Signal     string    `json:"signal,omitempty"` // 被信号终止时的信号名, 如 SIGKILL(可能是 OOM)
+	CoreDumped bool      `json:"core_dumped,omitempty"`
+	PID        int       `json:"pid,omitempty"`
+	UptimeSec  float64   `json:"uptime_sec,omitempty"` // process.exited: 进程运行了多久
+	Restarts   int       `json:"restarts,omitempty"`   // 连续自动重启次数
+	Error      string    `json:"error,omitempty"`
+	StopResult string    `json:"stop_result,omitempty"` // graceful / killed, only set for manual stops
+	State      string    `json:"state,omitempty"`       // process.state_changed: 新状态
+	PrevState  string    `json:"prev_state,omitempty"`  // process.state_changed: 之前的状态
+	LogTail    []string  `json:"log_tail,omitempty"`    // 失败事件: 最近的 stdout / stderr 输出
+}
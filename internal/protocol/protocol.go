@@ -0,0 +1,114 @@
+// Package protocol 定义 superd unix socket 上的 JSON 协议.
+//
+// 客户端每行发送一个 Request, superd 对每个请求回复一个 Response(同样一行一个);
+// logs -f 这类流式命令先回复若干 More=true 的 Response, 最后以 More=false 结束;
+// 流式命令是连接上的最后一个请求, 结束后 superd 关闭连接.
+// 首字节不是 '{' 的连接按旧的文本协议处理.
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Version 是当前协议版本; 请求中 V 为 0 时视为 1
+const Version = 1
+
+// Error codes
+const (
+	CodeBadRequest         = "bad_request"
+	CodeUnsupportedVersion = "unsupported_version"
+	CodeUnknownCommand     = "unknown_command"
+	CodeNotFound           = "not_found"
+	CodeFailed             = "failed"
+	CodeUnauthorized       = "unauthorized" // 仅 HTTP 接口: 没有或无效的 token
+	CodeForbidden          = "forbidden"    // token 没有所需的 scope, 或 socket 访问规则不允许
+)
+
+// Request 是一条 JSON 请求, 例如 {"v":1,"id":"1","cmd":"logs","args":["-n","10","web"]}.
+// Args 与文本协议中命令后的参数相同, 但可以包含空格.
+type Request struct {
+	V    int      `json:"v"`
+	ID   string   `json:"id,omitempty"`
+	Cmd  string   `json:"cmd"`
+	Args []string `json:"args,omitempty"`
+}
+
+// Response 是对 Request 的回复, ID 与请求相同
+type Response struct {
+	V      int             `json:"v"`
+	ID     string          `json:"id,omitempty"`
+	OK     bool            `json:"ok"`
+	More   bool            `json:"more,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *Error          `json:"error,omitempty"`
+}
+
+// Error 是失败请求的错误信息
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// Action 是 start / stop / restart 对单个服务的一次操作结果
+type Action struct {
+	Service    string `json:"service,omitempty"`
+	Action     string `json:"action"` // starting, started, running(已在运行, 没有再启动), restarted, stopped, failed, error
+	PID        int    `json:"pid,omitempty"`
+	StopResult string `json:"stop_result,omitempty"`
+	RequiredBy string `json:"required_by,omitempty"` // 因为依赖 RequiredBy 而被一并停止
+	Error      string `json:"error,omitempty"`
+}
+
+// Failed reports whether the action is an error.
+func (a Action) Failed() bool {
+	return a.Action == "failed" || a.Action == "error"
+}
+
+// String 返回文本协议中的对应行
+func (a Action) String() string {
+	switch a.Action {
+	case "starting":
+		return "starting: " + a.Service
+	case "started":
+		return fmt.Sprintf("started: %s PID=%d", a.Service, a.PID)
+	case "running":
+		if a.PID == 0 {
+			return "running: " + a.Service + " (already starting)"
+		}
+		return fmt.Sprintf("running: %s PID=%d (already running)", a.Service, a.PID)
+	case "restarted":
+		return fmt.Sprintf("restarted: %s PID=%d", a.Service, a.PID)
+	case "stopped":
+		if a.RequiredBy != "" {
+			return fmt.Sprintf("stopped: %s (%s, requires %s)", a.Service, a.StopResult, a.RequiredBy)
+		}
+		return fmt.Sprintf("stopped: %s (%s)", a.Service, a.StopResult)
+	case "failed":
+		return fmt.Sprintf("failed: %s error=%s", a.Service, a.Error)
+	}
+	return "error: " + a.Error
+}
+
+// ActionsResult 是 start / stop / restart 的结果
+type ActionsResult struct {
+	Actions []Action `json:"actions"`
+}
+
+// LogLine 是 logs 流式响应中的一行
+type LogLine struct {
+	Time   *time.Time `json:"time,omitempty"`
+	Stream string     `json:"stream,omitempty"`
+	Line   string     `json:"line"`
+}
+
+// UnitFile 是 cat 命令的结果
+type UnitFile struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
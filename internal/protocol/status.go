@@ -0,0 +1,46 @@
+package protocol
+
+import "time"
+
+// ServiceStatus 是一个服务的完整状态, list / status 命令和 HTTP API 返回它
+type ServiceStatus struct {
+	Name             string       `json:"name" yaml:"name"`
+	State            string       `json:"state" yaml:"state"`
+	StateSince       *time.Time   `json:"state_since,omitempty" yaml:"state_since,omitempty"`
+	PID              int          `json:"pid,omitempty" yaml:"pid,omitempty"`
+	StartedAt        *time.Time   `json:"started_at,omitempty" yaml:"started_at,omitempty"`
+	UptimeSec        int64        `json:"uptime_sec,omitempty" yaml:"uptime_sec,omitempty"`
+	Restarts         int          `json:"restarts" yaml:"restarts"` // 连续自动重启次数
+	LastExit         *LastExit    `json:"last_exit,omitempty" yaml:"last_exit,omitempty"`
+	LastStartError   string       `json:"last_start_error,omitempty" yaml:"last_start_error,omitempty"`
+	Command          []string     `json:"command,omitempty" yaml:"command,omitempty"`
+	WorkingDirectory string       `json:"working_directory,omitempty" yaml:"working_directory,omitempty"`
+	Transitions      []Transition `json:"transitions,omitempty" yaml:"transitions,omitempty"` // 最近的状态变化, 从旧到新
+}
+
+// LastExit 是最近一次退出的状态; 被信号终止时 Code 为 -1, 被接管的进程拿不到退出状态, 也为 -1
+type LastExit struct {
+	Code   int    `json:"code" yaml:"code"`
+	Signal string `json:"signal,omitempty" yaml:"signal,omitempty"`
+}
+
+// Transition 是一次状态变化
+type Transition struct {
+	From string    `json:"from" yaml:"from"`
+	To   string    `json:"to" yaml:"to"`
+	At   time.Time `json:"at" yaml:"at"`
+}
+
+// WebhookStatus 是一个 webhook 的投递状态, webhooks 命令返回它
+type WebhookStatus struct {
+	URL         string     `json:"url" yaml:"url"` // 隐去了 query 和密码
+	Pending     int        `json:"pending" yaml:"pending"`
+	Delivered   uint64     `json:"delivered" yaml:"delivered"`
+	Failed      uint64     `json:"failed" yaml:"failed"`   // 重试超过 max_age 或被接收方拒绝(4xx)
+	Dropped     uint64     `json:"dropped" yaml:"dropped"` // 队列满时丢弃的
+	Attempts    int        `json:"attempts,omitempty" yaml:"attempts,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty" yaml:"last_success,omitempty"`
+	LastAttempt *time.Time `json:"last_attempt,omitempty" yaml:"last_attempt,omitempty"`
+	LastError   string     `json:"last_error,omitempty" yaml:"last_error,omitempty"`
+	NextRetry   *time.Time `json:"next_retry,omitempty" yaml:"next_retry,omitempty"`
+}
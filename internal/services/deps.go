@@ -0,0 +1,278 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// unitName 把 "redis.service" 归一化为 "redis"; 其他类型的 unit(如 network.target)原样返回
+func unitName(s string) string {
+	return strings.TrimSuffix(s, ".service")
+}
+
+// StartOrder 根据 After= / Before= 计算启动顺序(拓扑排序).
+// 存在循环依赖时, 循环内的服务按名称追加到末尾, 同时返回描述循环的错误.
+func StartOrder(configs map[string]ServiceConfig) ([]string, error) {
+	edges, indegree := orderEdges(configs)
+
+	var ready []string
+	for name, n := range indegree {
+		if n == 0 {
+			ready = append(ready, name)
+		}
+	}
+	sort.Strings(ready)
+
+	order := make([]string, 0, len(configs))
+	for len(ready) > 0 {
+		name := ready[0]
+		ready = ready[1:]
+		order = append(order, name)
+
+		next := make([]string, 0, len(edges[name]))
+		for to := range edges[name] {
+			indegree[to]--
+			if indegree[to] == 0 {
+				next = append(next, to)
+			}
+		}
+		sort.Strings(next)
+		ready = append(ready, next...)
+		sort.Strings(ready)
+	}
+
+	if len(order) == len(configs) {
+		return order, nil
+	}
+
+	var rest []string
+	for name, n := range indegree {
+		if n > 0 {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	cycle := findCycle(rest, edges)
+	return append(order, rest...), fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> "))
+}
+
+// orderEdges 根据 After= / Before= 建图: edges[a] 包含 b 表示 a 必须在 b 之前启动
+func orderEdges(configs map[string]ServiceConfig) (map[string]map[string]bool, map[string]int) {
+	edges := make(map[string]map[string]bool, len(configs))
+	indegree := make(map[string]int, len(configs))
+	for name := range configs {
+		edges[name] = make(map[string]bool)
+		indegree[name] = 0
+	}
+	addEdge := func(from, to string) {
+		if _, ok := configs[from]; !ok {
+			return
+		}
+		if _, ok := configs[to]; !ok || from == to || edges[from][to] {
+			return
+		}
+		edges[from][to] = true
+		indegree[to]++
+	}
+	for name, cfg := range configs {
+		for _, dep := range cfg.After {
+			addEdge(dep, name)
+		}
+		for _, dep := range cfg.Before {
+			addEdge(name, dep)
+		}
+	}
+	return edges, indegree
+}
+
+// StopLevels 把服务按停止顺序分层: 同一层的服务之间没有顺序要求, 可以并行停止,
+// 每一层都要在前一层全部停止之后再停止.
+func StopLevels(configs map[string]ServiceConfig) [][]string {
+	edges, _ := orderEdges(configs)
+	order, _ := StartOrder(configs)
+	depth := make(map[string]int, len(order))
+	for _, name := range order {
+		for to := range edges[name] {
+			if d := depth[name] + 1; d > depth[to] {
+				depth[to] = d
+			}
+		}
+	}
+	max := 0
+	for _, d := range depth {
+		if d > max {
+			max = d
+		}
+	}
+	levels := make([][]string, max+1)
+	for _, name := range order {
+		i := max - depth[name]
+		levels[i] = append(levels[i], name)
+	}
+	return levels
+}
+
+// StopOrder 返回与启动顺序相反的停止顺序
+func StopOrder(configs map[string]ServiceConfig) []string {
+	order, _ := StartOrder(configs)
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	return order
+}
+
+// Dependencies 返回 name 通过 Requires= / Wants= / BindsTo= 直接或间接拉起的服务(不含自身), 按启动顺序排列
+func Dependencies(configs map[string]ServiceConfig, name string) []string {
+	seen := map[string]bool{name: true}
+	queue := []string{name}
+	for len(queue) > 0 {
+		cfg := configs[queue[0]]
+		queue = queue[1:]
+		for _, list := range [][]string{cfg.Requires, cfg.Wants, cfg.BindsTo} {
+			for _, dep := range list {
+				if _, ok := configs[dep]; ok && !seen[dep] {
+					seen[dep] = true
+					queue = append(queue, dep)
+				}
+			}
+		}
+	}
+	order, _ := StartOrder(configs)
+	deps := make([]string, 0, len(seen)-1)
+	for _, n := range order {
+		if seen[n] && n != name {
+			deps = append(deps, n)
+		}
+	}
+	return deps
+}
+
+// Requirements 返回 name 通过 Requires= / BindsTo= 直接或间接依赖的服务(不含自身).
+// 这些服务启动失败时 name 也不能启动; Wants= 拉起的服务失败不影响 name.
+func Requirements(configs map[string]ServiceConfig, name string) map[string]bool {
+	seen := map[string]bool{name: true}
+	queue := []string{name}
+	for len(queue) > 0 {
+		cfg := configs[queue[0]]
+		queue = queue[1:]
+		for _, list := range [][]string{cfg.Requires, cfg.BindsTo} {
+			for _, dep := range list {
+				if _, ok := configs[dep]; ok && !seen[dep] {
+					seen[dep] = true
+					queue = append(queue, dep)
+				}
+			}
+		}
+	}
+	delete(seen, name)
+	return seen
+}
+
+// BoundBy 返回通过 BindsTo= 直接绑定到 name 的服务, 按名称排序.
+// name 停止或退出时, 这些服务也要停止.
+func BoundBy(configs map[string]ServiceConfig, name string) []string {
+	var bound []string
+	for svc, cfg := range configs {
+		if svc != name && contains(cfg.BindsTo, name) {
+			bound = append(bound, svc)
+		}
+	}
+	sort.Strings(bound)
+	return bound
+}
+
+// RequiredBy 返回通过 Requires= / BindsTo= 直接或间接依赖 name 的服务(不含自身), 按停止顺序排列.
+// name 被显式停止时, 这些服务也要一起停止.
+func RequiredBy(configs map[string]ServiceConfig, name string) []string {
+	seen := map[string]bool{name: true}
+	queue := []string{name}
+	for len(queue) > 0 {
+		target := queue[0]
+		queue = queue[1:]
+		for svc, cfg := range configs {
+			if seen[svc] {
+				continue
+			}
+			if contains(cfg.Requires, target) || contains(cfg.BindsTo, target) {
+				seen[svc] = true
+				queue = append(queue, svc)
+			}
+		}
+	}
+	dependents := make([]string, 0, len(seen)-1)
+	for _, n := range StopOrder(configs) {
+		if seen[n] && n != name {
+			dependents = append(dependents, n)
+		}
+	}
+	return dependents
+}
+
+// MissingRequirements 列出 Requires= / BindsTo= 指向但未在 /etc/super 中定义的服务
+func MissingRequirements(configs map[string]ServiceConfig) []string {
+	var missing []string
+	for name, cfg := range configs {
+		for _, list := range [][]string{cfg.Requires, cfg.BindsTo} {
+			for _, dep := range list {
+				if _, ok := configs[dep]; !ok && !strings.Contains(dep, ".") {
+					missing = append(missing, name+" requires "+dep)
+				}
+			}
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+func findCycle(nodes []string, edges map[string]map[string]bool) []string {
+	inRest := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		inRest[n] = true
+	}
+	var path []string
+	onPath := make(map[string]int)
+	visited := make(map[string]bool)
+	var dfs func(n string) []string
+	dfs = func(n string) []string {
+		if i, ok := onPath[n]; ok {
+			return append(append([]string{}, path[i:]...), n)
+		}
+		if visited[n] {
+			return nil
+		}
+		visited[n] = true
+		onPath[n] = len(path)
+		path = append(path, n)
+		next := make([]string, 0, len(edges[n]))
+		for to := range edges[n] {
+			if inRest[to] {
+				next = append(next, to)
+			}
+		}
+		sort.Strings(next)
+		for _, to := range next {
+			if c := dfs(to); c != nil {
+				return c
+			}
+		}
+		path = path[:len(path)-1]
+		delete(onPath, n)
+		return nil
+	}
+	for _, n := range nodes {
+		if c := dfs(n); c != nil {
+			return c
+		}
+	}
+	return nodes
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testConfigs 构造只包含依赖关系的配置, deps 中的每一项形如 "web After=db Requires=db"
+func testConfigs(deps ...string) map[string]ServiceConfig {
+	configs := make(map[string]ServiceConfig, len(deps))
+	for _, d := range deps {
+		fields := strings.Fields(d)
+		cfg := ServiceConfig{Name: fields[0]}
+		for _, f := range fields[1:] {
+			kv := strings.SplitN(f, "=", 2)
+			list := strings.Split(kv[1], ",")
+			switch kv[0] {
+			case "After":
+				cfg.After = list
+			case "Before":
+				cfg.Before = list
+			case "Requires":
+				cfg.Requires = list
+			case "Wants":
+				cfg.Wants = list
+			case "BindsTo":
+				cfg.BindsTo = list
+			}
+		}
+		configs[cfg.Name] = cfg
+	}
+	return configs
+}
+
+func TestStartOrder(t *testing.T) {
+	cases := []struct {
+		name    string
+		configs map[string]ServiceConfig
+		want    []string
+		cycle   string // 为空表示没有循环
+	}{
+		{"no ordering", testConfigs("c", "a", "b"), []string{"a", "b", "c"}, ""},
+		{"after chain", testConfigs("worker After=web", "web After=db", "db"), []string{"db", "web", "worker"}, ""},
+		{"before", testConfigs("api Before=web", "web After=db", "db"), []string{"api", "db", "web"}, ""},
+		{"requires does not order", testConfigs("a Requires=b", "b"), []string{"a", "b"}, ""},
+		{"unknown and external units are ignored", testConfigs("web After=network.target,ghost", "db Before=web"), []string{"db", "web"}, ""},
+		{"self ordering is ignored", testConfigs("a After=a"), []string{"a"}, ""},
+		{"cycle", testConfigs("a After=b", "b After=c", "c After=a", "d"), []string{"d", "a", "b", "c"}, "a -> c -> b -> a"},
+		{"two-service cycle after a chain", testConfigs("db", "x After=db,y", "y After=x"), []string{"db", "x", "y"}, "x -> y -> x"},
+	}
+	for _, c := range cases {
+		order, err := StartOrder(c.configs)
+		if !reflect.DeepEqual(order, c.want) {
+			t.Errorf("%s: StartOrder = %v, want %v", c.name, order, c.want)
+		}
+		switch {
+		case c.cycle == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		case c.cycle != "" && (err == nil || err.Error() != "dependency cycle: "+c.cycle):
+			t.Errorf("%s: err = %v, want dependency cycle: %s", c.name, err, c.cycle)
+		}
+	}
+}
+
+func TestStopLevels(t *testing.T) {
+	cases := []struct {
+		name    string
+		configs map[string]ServiceConfig
+		want    [][]string
+	}{
+		{"independent", testConfigs("b", "a"), [][]string{{"a", "b"}}},
+		{"chain", testConfigs("worker After=web", "web After=db", "db"), [][]string{{"worker"}, {"web"}, {"db"}}},
+		{"parallel level", testConfigs("db", "web After=db", "cache After=db", "worker After=web"), [][]string{{"worker"}, {"cache", "web"}, {"db"}}},
+		// 最长路径决定层次: api 依赖 db 和 web, 必须在 web 之前停止
+		{"longest path", testConfigs("db", "web After=db", "api After=db,web"), [][]string{{"api"}, {"web"}, {"db"}}},
+	}
+	for _, c := range cases {
+		if got := StopLevels(c.configs); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: StopLevels = %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	// 有循环时仍然返回全部服务
+	n := 0
+	for _, level := range StopLevels(testConfigs("a After=b", "b After=a", "c")) {
+		n += len(level)
+	}
+	if n != 3 {
+		t.Errorf("StopLevels with a cycle returned %d services, want 3", n)
+	}
+}
+
+func TestRequiredByAndBoundBy(t *testing.T) {
+	configs := testConfigs(
+		"db",
+		"web Requires=db After=db",
+		"worker BindsTo=web After=web",
+		"api Requires=web After=web",
+		"cron Wants=db After=db",
+		"ghost-user Requires=ghost",
+	)
+	cases := []struct {
+		name string
+		want []string
+	}{
+		// 按停止顺序排列, Wants= 不会连带停止
+		{"db", []string{"worker", "api", "web"}},
+		{"web", []string{"worker", "api"}},
+		{"worker", nil},
+		{"cron", nil},
+		{"ghost", []string{"ghost-user"}},
+	}
+	for _, c := range cases {
+		got := RequiredBy(configs, c.name)
+		if len(got) != len(c.want) || len(got) > 0 && !reflect.DeepEqual(got, c.want) {
+			t.Errorf("RequiredBy(%s) = %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	bound := testConfigs("web", "worker BindsTo=web", "sidecar BindsTo=web,db", "api Requires=web", "db")
+	if got := BoundBy(bound, "web"); !reflect.DeepEqual(got, []string{"sidecar", "worker"}) {
+		t.Errorf("BoundBy(web) = %v, want [sidecar worker]", got)
+	}
+	if got := BoundBy(bound, "api"); len(got) != 0 {
+		t.Errorf("BoundBy(api) = %v, want none", got)
+	}
+}
+
+func TestMissingRequirements(t *testing.T) {
+	configs := testConfigs(
+		"web Requires=db,ghost After=db",
+		"api BindsTo=gone Wants=optional",
+		"net Requires=network.target",
+		"db",
+	)
+	want := []string{"api requires gone", "web requires ghost"}
+	if got := MissingRequirements(configs); !reflect.DeepEqual(got, want) {
+		t.Errorf("MissingRequirements = %v, want %v", got, want)
+	}
+	// 缺失的依赖不会被拉起, 也不会阻止计算其他依赖
+	if got := Dependencies(configs, "web"); !reflect.DeepEqual(got, []string{"db"}) {
+		t.Errorf("Dependencies(web) = %v, want [db]", got)
+	}
+	if got := Requirements(configs, "web"); !reflect.DeepEqual(got, map[string]bool{"db": true}) {
+		t.Errorf("Requirements(web) = %v, want db", got)
+	}
+	if got := MissingRequirements(testConfigs("web Requires=db", "db")); len(got) != 0 {
+		t.Errorf("MissingRequirements = %v, want none", got)
+	}
+}
@@ -3,29 +3,107 @@ package services
 
 import (
 	"fmt"
-	"io/ioutil"
+	"os"
 	"path/filepath"
-	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/litongjava/supers/internal/logger"
 	"github.com/litongjava/supers/internal/process"
 )
 
 // ServiceConfig holds one .service 文件解析后的信息
 type ServiceConfig struct {
 	Name             string
+	Description      string
 	Cmd              []string
 	RestartPolicy    process.RestartPolicy
 	WorkingDirectory string
-	Env              []string
+	// WorkingDirectory= 的 "-" 前缀: 目录不存在时忽略
+	WorkingDirectoryOptional bool
+	Env                      []string
+	StopPolicy               process.StopPolicy
+
+	// StandardOutput= / StandardError=, 默认分别写入 stdout.log 和 stderr.log
+	StandardOutput logger.Output
+	StandardError  logger.Output
+
+	// [Log] 或 [Service] 中的 X-Super-Log*=, 未配置的项取 config.yml 中的全局默认值
+	LogDir      string
+	LogRotation logger.Rotation
+	LogFormat   string
+
+	// ExecStart= 前缀: "@" 指定 argv[0], "-" 忽略失败退出码, "+" 以完整权限运行
+	Argv0          string
+	IgnoreFailure  bool
+	FullPrivileges bool
+
+	User                string
+	Group               string
+	SupplementaryGroups []string
+	UMask               string
+
+	// [Unit] 依赖关系, 已去掉 .service 后缀
+	After    []string
+	Before   []string
+	Requires []string
+	Wants    []string
+	BindsTo  []string
+}
+
+// Spec converts the config into the process.Spec used by process.Manage.
+func (c ServiceConfig) Spec() process.Spec {
+	spec := process.Spec{
+		Cmd:              c.Cmd,
+		Argv0:            c.Argv0,
+		IgnoreFailure:    c.IgnoreFailure,
+		WorkingDirectory: c.WorkingDirectory,
+		OptionalWorkDir:  c.WorkingDirectoryOptional,
+		Env:              c.Env,
+		Restart:          c.RestartPolicy,
+		Stop:             c.StopPolicy,
+		Log: logger.Options{
+			Stdout:   c.StandardOutput,
+			Stderr:   c.StandardError,
+			Dir:      c.LogDir,
+			Rotation: c.LogRotation,
+			Format:   c.LogFormat,
+		},
+	}
+	if !c.FullPrivileges {
+		spec.Credential = process.Credential{
+			User:                c.User,
+			Group:               c.Group,
+			SupplementaryGroups: c.SupplementaryGroups,
+			UMask:               c.UMask,
+		}
+	}
+	return spec
 }
 
-// 解析一行 Environment="FOO=bar" VAR2=baz
-var envLineRe = regexp.MustCompile(`"([^"]+)"|(\S+)`)
+// LoadError 汇总 LoadConfigs 中解析失败的文件, key 为服务名
+type LoadError struct {
+	Errors map[string]error
+}
 
-// LoadConfigs 读取 dir 下所有 *.service
+func (e *LoadError) Error() string {
+	names := make([]string, 0, len(e.Errors))
+	for name := range e.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, 0, len(names))
+	for _, name := range names {
+		msgs = append(msgs, e.Errors[name].Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// LoadConfigs 读取 dir 下所有 *.service.
+// 单个文件解析失败不影响其他文件, 失败的文件通过 *LoadError 返回.
 func LoadConfigs(dir string) (map[string]ServiceConfig, error) {
 	pattern := filepath.Join(dir, "*.service")
 	files, err := filepath.Glob(pattern)
@@ -34,111 +112,439 @@ func LoadConfigs(dir string) (map[string]ServiceConfig, error) {
 	}
 
 	services := make(map[string]ServiceConfig)
+	var loadErr *LoadError
 	for _, file := range files {
 		name := strings.TrimSuffix(filepath.Base(file), ".service")
-		b, err := ioutil.ReadFile(file)
+		cfg, err := loadFile(name, file)
 		if err != nil {
-			return nil, fmt.Errorf("read %s: %w", file, err)
-		}
-		lines := strings.Split(string(b), "\n")
-
-		var execStart, workDir string
-		var envs []string
-		restartDelay := 5 * time.Second
-
-		for _, l := range lines {
-			l = strings.TrimSpace(l)
-			switch {
-			case strings.HasPrefix(l, "ExecStart="):
-				execStart = strings.TrimPrefix(l, "ExecStart=")
-			case strings.HasPrefix(l, "WorkingDirectory="):
-				workDir = strings.TrimPrefix(l, "WorkingDirectory=")
-			case strings.HasPrefix(l, "RestartSec="):
-				if d, err := time.ParseDuration(strings.TrimPrefix(l, "RestartSec=")); err == nil {
-					restartDelay = d
-				}
-			case strings.HasPrefix(l, "Environment="):
-				raw := strings.TrimPrefix(l, "Environment=")
-				for _, m := range envLineRe.FindAllStringSubmatch(raw, -1) {
-					kv := m[1]
-					if kv == "" {
-						kv = m[2]
-					}
-					envs = append(envs, kv)
-				}
+			if loadErr == nil {
+				loadErr = &LoadError{Errors: make(map[string]error)}
 			}
-		}
-
-		if execStart == "" {
+			loadErr.Errors[name] = err
 			continue
 		}
-
-		parts := strings.Fields(execStart)
-		policy := process.RestartPolicy{
-			MaxRetries:    -1,
-			Delay:         restartDelay,
-			RestartOnZero: false,
-		}
-
-		services[name] = ServiceConfig{
-			Name:             name,
-			Cmd:              parts,
-			RestartPolicy:    policy,
-			WorkingDirectory: workDir,
-			Env:              envs,
-		}
+		services[name] = cfg
 	}
 
+	if loadErr != nil {
+		return services, loadErr
+	}
 	return services, nil
 }
 
+// LoadConfigFile 读取并解析 dir/name.service
 func LoadConfigFile(dir, name string) (ServiceConfig, error) {
-	path := filepath.Join(dir, name+".service")
-	data, err := ioutil.ReadFile(path)
+	path, err := UnitPath(dir, name)
+	if err != nil {
+		return ServiceConfig{}, err
+	}
+	cfg, err := loadFile(name, path)
 	if err != nil {
 		return ServiceConfig{}, err
 	}
-	lines := strings.Split(string(data), "\n")
-
-	var execStart, workDir string
-	var envs []string
-	restartDelay := 5 * time.Second
-
-	for _, l := range lines {
-		l = strings.TrimSpace(l)
-		switch {
-		case strings.HasPrefix(l, "ExecStart="):
-			execStart = strings.TrimPrefix(l, "ExecStart=")
-		case strings.HasPrefix(l, "WorkingDirectory="):
-			workDir = strings.TrimPrefix(l, "WorkingDirectory=")
-		case strings.HasPrefix(l, "RestartSec="):
-			if d, err := time.ParseDuration(strings.TrimPrefix(l, "RestartSec=")); err == nil {
-				restartDelay = d
+	hlog.Infof("Loaded service %s: args=%v, workDir=%s, env=%v", name, cfg.Cmd, cfg.WorkingDirectory, cfg.Env)
+	return cfg, nil
+}
+
+func loadFile(name, path string) (ServiceConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ServiceConfig{}, err
+	}
+	defer f.Close()
+
+	unit, err := ParseUnit(f, path)
+	if err != nil {
+		return ServiceConfig{}, err
+	}
+	return FromUnit(name, unit)
+}
+
+// keyHandler 把一条赋值应用到 ServiceConfig 上
+type keyHandler func(c *ServiceConfig, value string) error
+
+var unitKeys = map[string]keyHandler{
+	"Description": func(c *ServiceConfig, v string) error { c.Description = v; return nil },
+	"After":       unitList(func(c *ServiceConfig) *[]string { return &c.After }),
+	"Before":      unitList(func(c *ServiceConfig) *[]string { return &c.Before }),
+	"Requires":    unitList(func(c *ServiceConfig) *[]string { return &c.Requires }),
+	"Wants":       unitList(func(c *ServiceConfig) *[]string { return &c.Wants }),
+	"BindsTo":     unitList(func(c *ServiceConfig) *[]string { return &c.BindsTo }),
+
+	"StartLimitIntervalSec": startLimitInterval,
+	"StartLimitBurst":       startLimitBurst,
+}
+
+// 旧版 systemd 允许把启动频率限制写在 [Service] 中
+var (
+	startLimitInterval keyHandler = func(c *ServiceConfig, v string) error {
+		d, err := parseSec(v)
+		c.RestartPolicy.StartLimitInterval = d
+		return err
+	}
+	startLimitBurst keyHandler = func(c *ServiceConfig, v string) error {
+		n, err := strconv.Atoi(v)
+		if err == nil && n < 0 {
+			err = fmt.Errorf("negative burst %d", n)
+		}
+		c.RestartPolicy.StartLimitBurst = n
+		return err
+	}
+)
+
+var serviceKeys = map[string]keyHandler{
+	"ExecStart": func(c *ServiceConfig, v string) error {
+		if v == "" {
+			c.Cmd, c.Argv0, c.IgnoreFailure, c.FullPrivileges = nil, "", false, false
+			return nil
+		}
+		ec, err := ParseExec(v)
+		if err != nil {
+			return err
+		}
+		c.Cmd, c.Argv0, c.IgnoreFailure, c.FullPrivileges = ec.Argv, ec.Argv0, ec.IgnoreFailure, ec.FullPrivileges
+		return nil
+	},
+	"WorkingDirectory": func(c *ServiceConfig, v string) error {
+		// "-" 前缀表示目录不存在时忽略, 在启动时处理
+		c.WorkingDirectory = strings.TrimPrefix(v, "-")
+		c.WorkingDirectoryOptional = strings.HasPrefix(v, "-")
+		return nil
+	},
+	"Environment": func(c *ServiceConfig, v string) error {
+		if v == "" {
+			c.Env = nil
+			return nil
+		}
+		assignments, err := SplitQuoted(v)
+		if err != nil {
+			return err
+		}
+		for _, kv := range assignments {
+			if !strings.Contains(kv, "=") {
+				return fmt.Errorf("invalid environment assignment %q", kv)
+			}
+		}
+		c.Env = append(c.Env, assignments...)
+		return nil
+	},
+	"Restart": func(c *ServiceConfig, v string) error {
+		m, err := process.ParseRestartMode(v)
+		c.RestartPolicy.Mode = m
+		return err
+	},
+	"RestartPreventExitStatus": exitStatusList(func(c *ServiceConfig) *process.ExitStatusSet { return &c.RestartPolicy.PreventExitStatus }),
+	"SuccessExitStatus":        exitStatusList(func(c *ServiceConfig) *process.ExitStatusSet { return &c.RestartPolicy.SuccessExitStatus }),
+	"RestartForceExitStatus":   exitStatusList(func(c *ServiceConfig) *process.ExitStatusSet { return &c.RestartPolicy.ForceExitStatus }),
+	"RestartSec": func(c *ServiceConfig, v string) error {
+		d, err := parseSec(v)
+		c.RestartPolicy.Delay = d
+		return err
+	},
+	"RestartMaxDelaySec": func(c *ServiceConfig, v string) error {
+		d, err := parseTimeout(v)
+		c.RestartPolicy.MaxDelay = d
+		return err
+	},
+	"RestartSteps": func(c *ServiceConfig, v string) error {
+		n, err := strconv.Atoi(v)
+		if err == nil && n < 0 {
+			err = fmt.Errorf("negative steps %d", n)
+		}
+		c.RestartPolicy.Steps = n
+		return err
+	},
+	"X-Super-RestartJitterSec": func(c *ServiceConfig, v string) error {
+		d, err := parseSec(v)
+		c.RestartPolicy.Jitter = d
+		return err
+	},
+	"StartLimitIntervalSec": startLimitInterval,
+	"StartLimitBurst":       startLimitBurst,
+	"StandardOutput": func(c *ServiceConfig, v string) error {
+		out, err := logger.ParseOutput(v)
+		if err == nil && out.Kind == logger.OutputInherit {
+			err = fmt.Errorf("inherit is only valid for StandardError")
+		}
+		c.StandardOutput = out
+		return err
+	},
+	"StandardError": func(c *ServiceConfig, v string) error {
+		out, err := logger.ParseOutput(v)
+		c.StandardError = out
+		return err
+	},
+	"KillSignal": func(c *ServiceConfig, v string) error {
+		sig, err := process.ParseSignal(v)
+		c.StopPolicy.Signal = sig
+		return err
+	},
+	"TimeoutStopSec": func(c *ServiceConfig, v string) error {
+		d, err := parseTimeout(v)
+		c.StopPolicy.Timeout = d
+		return err
+	},
+	"KillMode": func(c *ServiceConfig, v string) error {
+		m, err := process.ParseKillMode(v)
+		c.StopPolicy.KillMode = m
+		return err
+	},
+	"SendSIGKILL": func(c *ServiceConfig, v string) error {
+		b, err := parseBool(v)
+		c.StopPolicy.SendSIGKILL = b
+		return err
+	},
+	"User":  func(c *ServiceConfig, v string) error { c.User = v; return nil },
+	"Group": func(c *ServiceConfig, v string) error { c.Group = v; return nil },
+	"SupplementaryGroups": func(c *ServiceConfig, v string) error {
+		if v == "" {
+			c.SupplementaryGroups = nil
+		}
+		c.SupplementaryGroups = append(c.SupplementaryGroups, strings.Fields(v)...)
+		return nil
+	},
+	"UMask": func(c *ServiceConfig, v string) error {
+		_, err := process.ParseUMask(v)
+		c.UMask = v
+		return err
+	},
+}
+
+// logKeys 是 superd 扩展的 [Log] 段; 同样的配置也可以在 [Service] 中写成 X-Super-Log<Key>=
+var logKeys = map[string]keyHandler{
+	"Directory": func(c *ServiceConfig, v string) error {
+		if v != "" && !filepath.IsAbs(v) {
+			return fmt.Errorf("directory must be absolute: %q", v)
+		}
+		c.LogDir = v
+		return nil
+	},
+	"MaxSize": func(c *ServiceConfig, v string) error {
+		n, err := parseSize(v)
+		c.LogRotation.MaxSize = n
+		return err
+	},
+	"MaxBackups": func(c *ServiceConfig, v string) error {
+		n, err := strconv.Atoi(v)
+		if err == nil && n < 0 {
+			err = fmt.Errorf("negative backups %d", n)
+		}
+		c.LogRotation.MaxBackups = n
+		return err
+	},
+	"MaxAge": func(c *ServiceConfig, v string) error {
+		n, err := parseDays(v)
+		c.LogRotation.MaxAge = n
+		return err
+	},
+	"Compress": func(c *ServiceConfig, v string) error {
+		b, err := parseBool(v)
+		c.LogRotation.Compress = b
+		return err
+	},
+	"Rotate": func(c *ServiceConfig, v string) error {
+		interval, err := logger.ParseInterval(v)
+		c.LogRotation.Interval = interval
+		return err
+	},
+	"Format": func(c *ServiceConfig, v string) error {
+		format, err := logger.ParseFormat(v)
+		c.LogFormat = format
+		return err
+	},
+}
+
+func init() {
+	for k, h := range logKeys {
+		serviceKeys["X-Super-Log"+k] = h
+	}
+}
+
+// FromUnit 把解析后的 unit 转换为 ServiceConfig; 值非法时返回带行号的错误
+func FromUnit(name string, u *Unit) (ServiceConfig, error) {
+	logDefaults := logger.GetDefaults()
+	cfg := ServiceConfig{
+		Name: name,
+		// 未配置 Restart= 时沿用 superd 以往的行为: 异常退出才重启
+		RestartPolicy: process.RestartPolicy{
+			Mode:            process.RestartOnFailure,
+			Delay:           5 * time.Second,
+			StartLimitBurst: 5, // 窗口由 DefaultStartLimit 按 RestartSec= 计算
+		},
+		StopPolicy:     process.DefaultStopPolicy(),
+		StandardOutput: logger.Output{Kind: logger.OutputFile},
+		StandardError:  logger.Output{Kind: logger.OutputFile},
+		LogDir:         logDefaults.Dir,
+		LogRotation:    logDefaults.Rotation,
+		LogFormat:      logDefaults.Format,
+	}
+
+	for _, section := range []struct {
+		name string
+		keys map[string]keyHandler
+	}{
+		{"Unit", unitKeys},
+		{"Service", serviceKeys},
+		{"Log", logKeys},
+	} {
+		for _, e := range u.Entries(section.name) {
+			h, ok := section.keys[e.Key]
+			if !ok {
+				hlog.Debugf("%s:%d: ignoring unsupported key %s in [%s]", u.Path, e.Line, e.Key, section.name)
+				continue
 			}
-		case strings.HasPrefix(l, "Environment="):
-			raw := strings.TrimPrefix(l, "Environment=")
-			for _, m := range envLineRe.FindAllStringSubmatch(raw, -1) {
-				kv := m[1]
-				if kv == "" {
-					kv = m[2]
-				}
-				envs = append(envs, kv)
+			if err := h(&cfg, e.Value); err != nil {
+				return ServiceConfig{}, &ParseError{Path: u.Path, Line: e.Line, Msg: fmt.Sprintf("%s=: %v", e.Key, err)}
 			}
 		}
 	}
 
-	if execStart == "" {
-		return ServiceConfig{}, fmt.Errorf("no ExecStart in %s", path)
+	if len(cfg.Cmd) == 0 {
+		return ServiceConfig{}, fmt.Errorf("no ExecStart in %s", u.Path)
 	}
-	parts := strings.Fields(execStart)
-	policy := process.RestartPolicy{-1, restartDelay, false}
+	_, inUnit := u.Value("Unit", "StartLimitIntervalSec")
+	_, inService := u.Value("Service", "StartLimitIntervalSec")
+	if !inUnit && !inService {
+		cfg.RestartPolicy.StartLimitInterval = cfg.RestartPolicy.DefaultStartLimit()
+	}
+	return cfg, nil
+}
+
+// unitList 处理空格分隔的 unit 列表, 空值清空之前的设置
+func unitList(field func(c *ServiceConfig) *[]string) keyHandler {
+	return func(c *ServiceConfig, v string) error {
+		list := field(c)
+		if v == "" {
+			*list = nil
+			return nil
+		}
+		for _, u := range strings.Fields(v) {
+			*list = append(*list, unitName(u))
+		}
+		return nil
+	}
+}
+
+// exitStatusList 处理退出码/信号列表, 空值清空之前的设置
+func exitStatusList(field func(c *ServiceConfig) *process.ExitStatusSet) keyHandler {
+	return func(c *ServiceConfig, v string) error {
+		set := field(c)
+		if v == "" {
+			*set = process.ExitStatusSet{}
+			return nil
+		}
+		parsed, err := process.ParseExitStatusSet(v)
+		if err != nil {
+			return err
+		}
+		*set = set.Merge(parsed)
+		return nil
+	}
+}
 
-	hlog.Infof("Loaded service %s: args=%v, workDir=%s, env=%v", name, parts, workDir, envs)
-	return ServiceConfig{
-		Name:             name,
-		Cmd:              parts,
-		RestartPolicy:    policy,
-		WorkingDirectory: workDir,
-		Env:              envs,
-	}, nil
+// timeUnits 是 systemd 时间跨度中的单位, 见 systemd.time(7)
+var timeUnits = map[string]time.Duration{
+	"us": time.Microsecond, "usec": time.Microsecond, "µs": time.Microsecond,
+	"ms": time.Millisecond, "msec": time.Millisecond,
+	"s": time.Second, "sec": time.Second, "second": time.Second, "seconds": time.Second,
+	"m": time.Minute, "min": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+	"M": 2629800 * time.Second, "month": 2629800 * time.Second, "months": 2629800 * time.Second,
+	"y": 31557600 * time.Second, "year": 31557600 * time.Second, "years": 31557600 * time.Second,
+}
+
+// parseSec 解析 systemd 风格的时间跨度, 如 "90"、"5s"、"1min 30s"、"2d"、"1.5h"; 没有单位时为秒.
+// "infinity" 只对 parseTimeout 有效
+func parseSec(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "infinity" {
+		return 0, fmt.Errorf("infinity is not allowed here")
+	}
+	if s == "" {
+		return 0, fmt.Errorf("empty time span")
+	}
+	var total time.Duration
+	for rest := s; rest != ""; rest = strings.TrimLeft(rest, " \t") {
+		i := 0
+		for i < len(rest) && (rest[i] >= '0' && rest[i] <= '9' || rest[i] == '.') {
+			i++
+		}
+		n, err := strconv.ParseFloat(rest[:i], 64)
+		if i == 0 || err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid time span %q", s)
+		}
+		rest = strings.TrimLeft(rest[i:], " \t")
+		j := 0
+		for j < len(rest) && !(rest[j] >= '0' && rest[j] <= '9' || rest[j] == '.' || rest[j] == ' ' || rest[j] == '\t') {
+			j++
+		}
+		unit := time.Second
+		if j > 0 {
+			u, ok := timeUnits[rest[:j]]
+			if !ok {
+				return 0, fmt.Errorf("invalid time unit %q in %q", rest[:j], s)
+			}
+			unit = u
+		}
+		total += time.Duration(n * float64(unit))
+		rest = rest[j:]
+	}
+	return total, nil
+}
+
+// parseTimeout 与 parseSec 相同, 但接受 "infinity", 返回 0 表示不限制
+func parseTimeout(s string) (time.Duration, error) {
+	if strings.TrimSpace(s) == "infinity" {
+		return 0, nil
+	}
+	return parseSec(s)
+}
+
+// parseSize 解析日志大小, 单位 MB: "100"、"100M"、"1G"
+func parseSize(s string) (int, error) {
+	s = strings.ToUpper(strings.TrimSuffix(strings.TrimSpace(s), "B"))
+	mul := 1
+	switch {
+	case strings.HasSuffix(s, "G"):
+		mul, s = 1024, strings.TrimSuffix(s, "G")
+	case strings.HasSuffix(s, "M"):
+		s = strings.TrimSuffix(s, "M")
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("size must be positive")
+	}
+	return n * mul, nil
+}
+
+// parseDays 解析保留天数: "7"、"7d"、"2w"
+func parseDays(s string) (int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	mul := 1
+	switch {
+	case strings.HasSuffix(s, "w"):
+		mul, s = 7, strings.TrimSuffix(s, "w")
+	case strings.HasSuffix(s, "d"):
+		s = strings.TrimSuffix(s, "d")
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+	return n * mul, nil
+}
+
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "yes", "true", "on", "1":
+		return true, nil
+	case "no", "false", "off", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid boolean %q", s)
 }
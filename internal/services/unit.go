@@ -0,0 +1,245 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Unit 是一个 .service 文件的解析结果: 按出现顺序保存各 section 及其键值
+type Unit struct {
+	Path     string
+	Sections []*Section
+}
+
+// Section 对应 [Unit] / [Service] / [Install] 等段落
+type Section struct {
+	Name    string
+	Line    int
+	Entries []Entry
+}
+
+// Entry 是一条 Key=Value 赋值, Line 为该赋值起始行号(从 1 开始)
+type Entry struct {
+	Key   string
+	Value string
+	Line  int
+}
+
+// ParseError 带文件名和行号的解析错误
+type ParseError struct {
+	Path string
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Msg)
+}
+
+// ParseUnit 解析 systemd 风格的 unit 文件.
+// 支持 # 和 ; 注释、行尾 \ 续行、Key = Value 两侧空格以及同名 section 多次出现.
+func ParseUnit(r io.Reader, path string) (*Unit, error) {
+	u := &Unit{Path: path}
+	var cur *Section
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimSpace(sc.Text())
+		start := lineNo
+
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		// 续行: 行尾的 \ 替换为空格并拼接下一行, 续行中的注释行被忽略
+		for strings.HasSuffix(line, "\\") && sc.Scan() {
+			lineNo++
+			next := strings.TrimSpace(sc.Text())
+			if next != "" && (next[0] == '#' || next[0] == ';') {
+				continue // line 仍以 \ 结尾, 继续拼接下一行
+			}
+			line = strings.TrimSuffix(line, "\\") + " " + next
+		}
+		line = strings.TrimSpace(strings.TrimSuffix(line, "\\"))
+		if line == "" {
+			continue
+		}
+
+		if line[0] == '[' {
+			if !strings.HasSuffix(line, "]") || len(line) < 3 {
+				return nil, &ParseError{Path: path, Line: start, Msg: fmt.Sprintf("invalid section header %q", line)}
+			}
+			cur = &Section{Name: line[1 : len(line)-1], Line: start}
+			u.Sections = append(u.Sections, cur)
+			continue
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return nil, &ParseError{Path: path, Line: start, Msg: fmt.Sprintf("missing '=' in %q", line)}
+		}
+		key := strings.TrimSpace(line[:eq])
+		if key == "" {
+			return nil, &ParseError{Path: path, Line: start, Msg: "empty key"}
+		}
+		if cur == nil {
+			return nil, &ParseError{Path: path, Line: start, Msg: fmt.Sprintf("assignment %q outside of any section", key)}
+		}
+		cur.Entries = append(cur.Entries, Entry{
+			Key:   key,
+			Value: strings.TrimSpace(line[eq+1:]),
+			Line:  start,
+		})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, &ParseError{Path: path, Line: lineNo + 1, Msg: err.Error()}
+	}
+	return u, nil
+}
+
+// Entries 返回 section 中所有赋值(同名 section 合并)
+func (u *Unit) Entries(section string) []Entry {
+	var entries []Entry
+	for _, s := range u.Sections {
+		if s.Name == section {
+			entries = append(entries, s.Entries...)
+		}
+	}
+	return entries
+}
+
+// Values 返回 section.key 的所有值; 空赋值(Key=)会清空之前累积的值
+func (u *Unit) Values(section, key string) []string {
+	var values []string
+	for _, e := range u.Entries(section) {
+		if e.Key != key {
+			continue
+		}
+		if e.Value == "" {
+			values = nil
+			continue
+		}
+		values = append(values, e.Value)
+	}
+	return values
+}
+
+// Value 返回 section.key 最后一次赋值
+func (u *Unit) Value(section, key string) (string, bool) {
+	entries := u.Entries(section)
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Key == key {
+			return entries[i].Value, true
+		}
+	}
+	return "", false
+}
+
+// SplitQuoted 按 systemd 的规则切分参数: 支持词首的单引号、双引号和反斜杠转义.
+// 与 shell 一致, 单引号中的内容(包括反斜杠)原样保留, 转义只在引号外和双引号中有效
+func SplitQuoted(s string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	inWord := false
+	var quote byte
+
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case quote != 0 && ch == quote:
+			quote = 0
+		case quote == '\'':
+			cur.WriteByte(ch)
+		case ch == '\\':
+			if i+1 >= len(s) {
+				return nil, fmt.Errorf("trailing backslash in %q", s)
+			}
+			i++
+			cur.WriteByte(unescape(s[i]))
+			inWord = true
+		case quote != 0:
+			cur.WriteByte(ch)
+		case (ch == '"' || ch == '\'') && !inWord:
+			// 与 systemd 一致, 只有词首的引号起作用, 词中间的引号按普通字符处理
+			quote = ch
+			inWord = true
+		case ch == ' ' || ch == '\t':
+			if inWord {
+				args = append(args, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteByte(ch)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in %q", quote, s)
+	}
+	if inWord {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+func unescape(ch byte) byte {
+	switch ch {
+	case 'n':
+		return '\n'
+	case 't':
+		return '\t'
+	case 'r':
+		return '\r'
+	}
+	return ch
+}
+
+// ExecCommand 是解析后的 ExecStart=
+type ExecCommand struct {
+	Argv           []string // Argv[0] 为可执行文件路径
+	Argv0          string   // "@" 前缀: 传给进程的 argv[0]
+	IgnoreFailure  bool     // "-" 前缀: 非零退出码不视为失败
+	FullPrivileges bool     // "+" 前缀: 忽略 User= / Group= 以完整权限运行
+}
+
+// ParseExec 解析带 -/@/+ 前缀和引号的命令行
+func ParseExec(value string) (ExecCommand, error) {
+	var ec ExecCommand
+	argv0 := false
+prefixes:
+	for len(value) > 0 {
+		switch value[0] {
+		case '-':
+			ec.IgnoreFailure = true
+		case '@':
+			argv0 = true
+		case '+':
+			ec.FullPrivileges = true
+		default:
+			break prefixes
+		}
+		value = value[1:]
+	}
+	args, err := SplitQuoted(value)
+	if err != nil {
+		return ec, err
+	}
+	if len(args) == 0 {
+		return ec, fmt.Errorf("empty command")
+	}
+	if argv0 {
+		if len(args) < 2 {
+			return ec, fmt.Errorf("'@' prefix requires argv[0] after the executable")
+		}
+		ec.Argv0 = args[1]
+		args = append(args[:1], args[2:]...)
+	}
+	ec.Argv = args
+	return ec, nil
+}
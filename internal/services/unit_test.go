@@ -0,0 +1,268 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseUnit(t *testing.T) {
+	const content = `# 注释
+; 另一种注释
+[Unit]
+Description = demo service
+
+[Service]
+ExecStart=/bin/app \
+  --flag \
+# 续行中的注释行被忽略
+  --other
+Environment=A=1
+Environment=
+Environment=B=2
+
+[Service]
+User=nobody
+`
+	u, err := ParseUnit(strings.NewReader(content), "demo.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u.Sections) != 3 {
+		t.Fatalf("sections = %d, want 3", len(u.Sections))
+	}
+	if v, _ := u.Value("Unit", "Description"); v != "demo service" {
+		t.Errorf("Description = %q", v)
+	}
+	entries := u.Entries("Service")
+	if len(entries) != 5 {
+		t.Fatalf("[Service] entries = %+v, want 5 (merged)", entries)
+	}
+	if e := entries[0]; e.Key != "ExecStart" || strings.Join(strings.Fields(e.Value), " ") != "/bin/app --flag --other" || e.Line != 7 {
+		t.Errorf("ExecStart entry = %+v", e)
+	}
+	if got := u.Values("Service", "Environment"); !reflect.DeepEqual(got, []string{"B=2"}) {
+		t.Errorf("Environment values = %q, want [B=2] after the reset", got)
+	}
+	if v, ok := u.Value("Service", "User"); !ok || v != "nobody" {
+		t.Errorf("User = %q, %v", v, ok)
+	}
+	if _, ok := u.Value("Service", "Group"); ok {
+		t.Error("Group should not be set")
+	}
+}
+
+func TestParseUnitErrors(t *testing.T) {
+	cases := []struct {
+		content string
+		line    int
+		msg     string
+	}{
+		{"[Service\nA=1\n", 1, "invalid section header"},
+		{"[]\n", 1, "invalid section header"},
+		{"[Service]\n\nExecStart\n", 3, "missing '='"},
+		{"[Service]\n =x\n", 2, "empty key"},
+		{"# c\nA=1\n", 2, "outside of any section"},
+		{"[Service]\nA=1 \\\n  B\nC\n", 4, "missing '='"},
+	}
+	for _, c := range cases {
+		_, err := ParseUnit(strings.NewReader(c.content), "bad.service")
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Errorf("%q: err = %v, want a ParseError", c.content, err)
+			continue
+		}
+		if pe.Line != c.line || !strings.Contains(pe.Msg, c.msg) || pe.Path != "bad.service" {
+			t.Errorf("%q: err = %v, want line %d containing %q", c.content, err, c.line, c.msg)
+		}
+	}
+}
+
+func TestSplitQuoted(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+		err  bool
+	}{
+		{in: "a b\tc", want: []string{"a", "b", "c"}},
+		{in: "  a   b  ", want: []string{"a", "b"}},
+		{in: "", want: nil},
+		{in: `"a b" c`, want: []string{"a b", "c"}},
+		{in: `'a b' c`, want: []string{"a b", "c"}},
+		{in: `"" x`, want: []string{"", "x"}},
+		{in: `"a \"b\" \\ \n"`, want: []string{"a \"b\" \\ \n"}},
+		{in: `a\ b c\td`, want: []string{"a b", "c\td"}},
+		// 单引号中的反斜杠原样保留
+		{in: `'a\nb' 'c\'`, want: []string{`a\nb`, `c\`}},
+		{in: `'C:\dir\file'`, want: []string{`C:\dir\file`}},
+		// 只有词首的引号起作用
+		{in: `a'b c'`, want: []string{"a'b", "c'"}},
+		{in: `a"b\c"`, want: []string{`a"bc"`}},
+		{in: `A="x y"`, want: []string{`A="x`, `y"`}},
+		{in: `"unterminated`, err: true},
+		{in: `'unterminated`, err: true},
+		{in: `trailing\`, err: true},
+	}
+	for _, c := range cases {
+		got, err := SplitQuoted(c.in)
+		if c.err {
+			if err == nil {
+				t.Errorf("SplitQuoted(%q) = %q, want an error", c.in, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("SplitQuoted(%q) = %q, %v, want %q", c.in, got, err, c.want)
+		}
+	}
+}
+
+func TestParseExec(t *testing.T) {
+	cases := []struct {
+		in   string
+		want ExecCommand
+		err  bool
+	}{
+		{in: "/bin/app -v", want: ExecCommand{Argv: []string{"/bin/app", "-v"}}},
+		{in: "-/bin/app", want: ExecCommand{Argv: []string{"/bin/app"}, IgnoreFailure: true}},
+		{in: "+/bin/app", want: ExecCommand{Argv: []string{"/bin/app"}, FullPrivileges: true}},
+		{in: "@/bin/app app-name -v", want: ExecCommand{Argv: []string{"/bin/app", "-v"}, Argv0: "app-name"}},
+		{in: "-+@/bin/app x", want: ExecCommand{Argv: []string{"/bin/app"}, Argv0: "x", IgnoreFailure: true, FullPrivileges: true}},
+		{in: `/bin/sh -c 'echo "$HOME"'`, want: ExecCommand{Argv: []string{"/bin/sh", "-c", `echo "$HOME"`}}},
+		{in: "@/bin/app", err: true},
+		{in: "-", err: true},
+		{in: "", err: true},
+		{in: `/bin/app "x`, err: true},
+	}
+	for _, c := range cases {
+		got, err := ParseExec(c.in)
+		if c.err {
+			if err == nil {
+				t.Errorf("ParseExec(%q) = %+v, want an error", c.in, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ParseExec(%q) = %+v, %v, want %+v", c.in, got, err, c.want)
+		}
+	}
+}
+
+func TestParseSec(t *testing.T) {
+	cases := []struct {
+		in   string
+		want time.Duration
+		err  bool
+	}{
+		{in: "90", want: 90 * time.Second},
+		{in: "5s", want: 5 * time.Second},
+		{in: "1min 30s", want: 90 * time.Second},
+		{in: "1min30s", want: 90 * time.Second},
+		{in: " 2 h ", want: 2 * time.Hour},
+		{in: "1.5h", want: 90 * time.Minute},
+		{in: "500ms", want: 500 * time.Millisecond},
+		{in: "2d", want: 48 * time.Hour},
+		{in: "1w 1d", want: 8 * 24 * time.Hour},
+		{in: "0", want: 0},
+		{in: "infinity", err: true},
+		{in: "", err: true},
+		{in: "5x", err: true},
+		{in: "min", err: true},
+		{in: "-5s", err: true},
+		{in: "1..5s", err: true},
+	}
+	for _, c := range cases {
+		got, err := parseSec(c.in)
+		if c.err {
+			if err == nil {
+				t.Errorf("parseSec(%q) = %v, want an error", c.in, got)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("parseSec(%q) = %v, %v, want %v", c.in, got, err, c.want)
+		}
+	}
+
+	if d, err := parseTimeout("infinity"); err != nil || d != 0 {
+		t.Errorf("parseTimeout(infinity) = %v, %v, want 0", d, err)
+	}
+	if d, err := parseTimeout("1min 30s"); err != nil || d != 90*time.Second {
+		t.Errorf("parseTimeout(1min 30s) = %v, %v", d, err)
+	}
+}
+
+func TestFromUnit(t *testing.T) {
+	const content = `[Unit]
+Requires=db.service
+
+[Service]
+ExecStart=-/bin/app --port 8080
+WorkingDirectory=-/srv/app
+RestartSec=1min 30s
+TimeoutStopSec=infinity
+Environment="A=x y" B=2
+`
+	u, err := ParseUnit(strings.NewReader(content), "app.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := FromUnit("app", u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.Cmd, []string{"/bin/app", "--port", "8080"}) || !cfg.IgnoreFailure {
+		t.Errorf("Cmd = %q, IgnoreFailure = %v", cfg.Cmd, cfg.IgnoreFailure)
+	}
+	if cfg.WorkingDirectory != "/srv/app" || !cfg.WorkingDirectoryOptional {
+		t.Errorf("WorkingDirectory = %q, optional = %v", cfg.WorkingDirectory, cfg.WorkingDirectoryOptional)
+	}
+	if cfg.RestartPolicy.Delay != 90*time.Second {
+		t.Errorf("RestartSec = %v, want 1m30s", cfg.RestartPolicy.Delay)
+	}
+	if cfg.StopPolicy.Timeout != 0 {
+		t.Errorf("TimeoutStopSec = %v, want 0", cfg.StopPolicy.Timeout)
+	}
+	if !reflect.DeepEqual(cfg.Env, []string{"A=x y", "B=2"}) {
+		t.Errorf("Env = %q", cfg.Env)
+	}
+	if !reflect.DeepEqual(cfg.Requires, []string{"db"}) {
+		t.Errorf("Requires = %q", cfg.Requires)
+	}
+}
+
+func TestFromUnitErrors(t *testing.T) {
+	cases := []struct {
+		content string
+		line    int
+		msg     string
+	}{
+		{"[Service]\nExecStart=/bin/app\nRestartSec=soon\n", 3, "RestartSec="},
+		{"[Service]\nExecStart=/bin/app\nRestartSec=infinity\n", 3, "RestartSec="},
+		{"[Service]\nExecStart=/bin/app 'x\n", 2, "ExecStart="},
+		{"[Service]\nExecStart=/bin/app\nEnvironment=NOVALUE\n", 3, "Environment="},
+		{"[Service]\nExecStart=/bin/app\nRestart=sometimes\n", 3, "Restart="},
+		{"[Service]\nExecStart=/bin/app\nKillSignal=99\n", 3, "out of range"},
+	}
+	for _, c := range cases {
+		u, err := ParseUnit(strings.NewReader(c.content), "bad.service")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = FromUnit("bad", u)
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.Line != c.line || !strings.Contains(pe.Msg, c.msg) {
+			t.Errorf("%q: err = %v, want line %d containing %q", c.content, err, c.line, c.msg)
+		}
+	}
+
+	u, err := ParseUnit(strings.NewReader("[Service]\nUser=nobody\n"), "noexec.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FromUnit("noexec", u); err == nil || !strings.Contains(err.Error(), "no ExecStart") {
+		t.Errorf("FromUnit without ExecStart = %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// validName 限制服务名只能由字母、数字和 _ . @ - 组成, 避免通过名字访问 dir 以外的文件
+var validName = regexp.MustCompile(`^[A-Za-z0-9_@-][A-Za-z0-9_.@-]*$`)
+
+// ValidName reports whether name can be used as a service (unit file) name.
+func ValidName(name string) bool {
+	return len(name) <= 128 && validName.MatchString(name)
+}
+
+// UnitPath 返回 dir 下服务 name 的 unit 文件路径
+func UnitPath(dir, name string) (string, error) {
+	if !ValidName(name) {
+		return "", fmt.Errorf("invalid service name %q", name)
+	}
+	return filepath.Join(dir, name+".service"), nil
+}
+
+// ReadUnitFile 返回 dir/name.service 的原始内容
+func ReadUnitFile(dir, name string) ([]byte, error) {
+	path, err := UnitPath(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(path)
+}
+
+// WriteUnitFile 校验 content 后写入 dir/name.service.
+// 先写临时文件再 rename, reload 不会读到写了一半的文件; 校验失败时不改动已有文件.
+func WriteUnitFile(dir, name string, content []byte) error {
+	path, err := UnitPath(dir, name)
+	if err != nil {
+		return err
+	}
+	unit, err := ParseUnit(bytes.NewReader(content), path)
+	if err != nil {
+		return err
+	}
+	if _, err := FromUnit(name, unit); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+name+".service.")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// RemoveUnitFile 删除 dir/name.service
+func RemoveUnitFile(dir, name string) error {
+	path, err := UnitPath(dir, name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -3,36 +3,181 @@ package utils
 import (
 	"fmt"
 	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/litongjava/supers/internal/auth"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
+	"sync/atomic"
+	"time"
 )
 
 type Config struct {
 	App    *App          `yaml:"app"`
 	Events *EventsConfig `yaml:"events"`
+	Log    *LogConfig    `yaml:"log"`
+	Socket *SocketConfig `yaml:"socket"`
+	State  *StateConfig  `yaml:"state"`
 }
 
 type App struct {
 	Port     int    `yaml:"port"`
+	Bind     string `yaml:"bind"` // HTTP 监听的地址, 为空时监听所有网卡
 	FilePath string `yaml:"filePath"`
-	Password string `yaml:"password"`
+	// Password 是旧的 ?p= 密码, 具有全部权限; 新的客户端应使用 Tokens
+	Password string             `yaml:"password"`
+	Tokens   []auth.TokenConfig `yaml:"tokens"`
+	TLS      *TLSConfig         `yaml:"tls"`
+	// ShutdownPolicy 决定 superd 收到 SIGTERM/SIGINT 时如何处理服务: stop(默认) 或 detach
+	ShutdownPolicy string `yaml:"shutdown_policy"`
+}
+
+// TLSConfig 为 HTTP 接口启用 HTTPS; 配置 ClientCA 时要求客户端证书(双向 TLS)
+type TLSConfig struct {
+	Cert       string              `yaml:"cert"`
+	Key        string              `yaml:"key"`
+	ClientCA   string              `yaml:"client_ca"`
+	ClientAuth string              `yaml:"client_auth"` // require(默认) / optional
+	Clients    []auth.ClientConfig `yaml:"clients"`
+}
+
+// StateConfig 是进程状态的持久化设置, 只在 superd 启动时读取
+type StateConfig struct {
+	File string `yaml:"file"` // 状态文件, 默认 /var/lib/super/state.json
+	// OutputFIFO 让子进程经状态文件所在目录下 fifo/ 中的 FIFO 输出, superd 重启后接管的进程可以继续写日志.
+	// superd 不在时没有人读取 FIFO, 写满内核缓冲区(约 64 KiB)后子进程会阻塞在 write 上
+	OutputFIFO bool `yaml:"output_fifo"`
+}
+
+// SocketConfig 是控制 unix socket 的位置、权限和访问规则
+type SocketConfig struct {
+	Path   string       `yaml:"path"`
+	Owner  string       `yaml:"owner"`
+	Group  string       `yaml:"group"`
+	Mode   string       `yaml:"mode"` // 八进制, 如 "0660"
+	Access []SocketRule `yaml:"access"`
+}
+
+// SocketRule 按连接方的 uid / gid(SO_PEERCRED) 授予 scope; Services 为空时适用于所有服务
+type SocketRule struct {
+	Users    []string `yaml:"users"`
+	Groups   []string `yaml:"groups"`
+	Scopes   []string `yaml:"scopes"` // status / deploy / admin
+	Services []string `yaml:"services"`
 }
 
 type EventsConfig struct {
-	Webhooks []string `yaml:"webhooks"`
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+	// webhook 投递设置, 对所有 URL 生效; 为 0 / 空时使用默认值
+	Timeout   time.Duration `yaml:"timeout"`    // 单次请求的超时, 默认 10s
+	MaxAge    time.Duration `yaml:"max_age"`    // 投递失败的事件最多重试多久, 默认 1h
+	QueueSize int           `yaml:"queue_size"` // 每个 URL 最多排队的事件数, 默认 1000
+	QueueDir  string        `yaml:"queue_dir"`  // 未投递事件的保存目录, 默认 /var/lib/super/webhooks
+
+	// 事件历史, 供 supers events 和 /api/events 查询
+	HistorySize int    `yaml:"history_size"` // 保留的事件数, 默认 1000
+	HistoryFile string `yaml:"history_file"` // 为空时只保存在内存中, superd 重启后清空
+
+	// Exec 是事件发生时在本机执行的命令
+	Exec []ExecHookConfig `yaml:"exec"`
+}
+
+// ExecHookConfig 是 events.exec 的一项. 事件的字段以 SUPERS_EVENT_* 环境变量传给命令,
+// 事件的 JSON 写到命令的 stdin, 命令的输出记入 superd 的日志
+type ExecHookConfig struct {
+	Name     string      `yaml:"name"` // 日志中显示的名字, 默认为命令
+	Command  ExecCommand `yaml:"command"`
+	Events   []string    `yaml:"events"`   // 同 WebhookConfig.Events
+	Services []string    `yaml:"services"` // 同 WebhookConfig.Services
+	// Timeout 之后杀掉命令的整个进程组, 默认 30s
+	Timeout time.Duration `yaml:"timeout"`
+	// Concurrency 是同时执行的最大数量, 默认 1; 其余的事件排队等待
+	Concurrency int `yaml:"concurrency"`
 }
 
-var CONFIG *Config
+// ExecCommand 是要执行的命令: 列表按参数执行, 字符串用 /bin/sh -c 执行
+type ExecCommand []string
+
+// UnmarshalYAML 接受字符串或字符串列表
+func (c *ExecCommand) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var script string
+	if err := unmarshal(&script); err == nil {
+		*c = nil
+		if script != "" {
+			*c = ExecCommand{"/bin/sh", "-c", script}
+		}
+		return nil
+	}
+	var argv []string
+	if err := unmarshal(&argv); err != nil {
+		return err
+	}
+	*c = argv
+	return nil
+}
+
+// WebhookConfig 是一个 webhook. 配置中可以只写 URL, 也可以写成带过滤、模板和签名的对象
+type WebhookConfig struct {
+	URL string `yaml:"url"`
+	// Events / Services 过滤事件类型和服务名, 支持 * 通配符(如 process.*), 为空表示全部
+	Events   []string `yaml:"events"`
+	Services []string `yaml:"services"`
+	// Template 是请求体的 text/template, 以 Event 为数据; 为空时发送 Event 的 JSON
+	Template    string            `yaml:"template"`
+	ContentType string            `yaml:"content_type"` // 默认 application/json
+	Headers     map[string]string `yaml:"headers"`      // 值同样是模板
+	// Secret 不为空时用 HMAC-SHA256 签名, 见 X-Supers-Signature
+	Secret string `yaml:"secret"`
+}
+
+// UnmarshalYAML 兼容旧的写法: webhooks 的每一项是一个 URL 字符串
+func (w *WebhookConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var url string
+	if err := unmarshal(&url); err == nil {
+		*w = WebhookConfig{URL: url}
+		return nil
+	}
+	type plain WebhookConfig
+	return unmarshal((*plain)(w))
+}
+
+// LogConfig 是服务日志的全局默认值, unit 文件中的 [Log] 可以逐项覆盖
+type LogConfig struct {
+	Dir        string `yaml:"dir"`
+	MaxSize    int    `yaml:"max_size"` // MB
+	MaxBackups *int   `yaml:"max_backups"`
+	MaxAge     *int   `yaml:"max_age"` // days
+	Compress   *bool  `yaml:"compress"`
+	Rotate     string `yaml:"rotate"` // daily / hourly
+	Format     string `yaml:"format"` // raw / text / json
+}
+
+// current 保存 *Config; reload 时整体替换, 读取方不需要加锁
+var current atomic.Value
 
 func init() {
-	yamlFile, err := ioutil.ReadFile("./config/config.yml")
-	if err != nil {
+	if err := LoadConfig(); err != nil {
 		hlog.Error(err.Error())
-		return
 	}
-	CONFIG = &Config{}
-	err = yaml.Unmarshal(yamlFile, CONFIG)
+}
+
+// CurrentConfig 返回最近一次成功读取的配置, 从未读取成功时返回空配置, 不会返回 nil.
+// 返回的配置不能修改; 同一次操作中应只调用一次, 以免前后读到不同的配置
+func CurrentConfig() *Config {
+	if c, ok := current.Load().(*Config); ok {
+		return c
+	}
+	return &Config{}
+}
+
+// LoadConfig 重新读取 ./config/config.yml, superd reload 时也会调用
+func LoadConfig() error {
+	yamlFile, err := ioutil.ReadFile("./config/config.yml")
 	if err != nil {
-		fmt.Println("error parsing config:", err)
+		return err
+	}
+	config := &Config{}
+	if err := yaml.Unmarshal(yamlFile, config); err != nil {
+		return fmt.Errorf("error parsing config: %v", err)
 	}
+	current.Store(config)
+	return nil
 }